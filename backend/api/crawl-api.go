@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+
+	"github.com/Tom-Mendy/SentryLink/controller"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/service"
+)
+
+type CrawlApi struct {
+	crawlController controller.CrawlController
+	// Origin of the frontend, the only other one allowed to open WebSockets
+	frontendOrigin string
+}
+
+func NewCrawlAPI(crawlController controller.CrawlController) *CrawlApi {
+	return &CrawlApi{
+		crawlController: crawlController,
+		frontendOrigin:  strings.TrimSuffix(os.Getenv("FRONTEND_ORIGIN"), "/"),
+	}
+}
+
+func (api *CrawlApi) CreateCrawl(ctx *gin.Context) {
+	job, err := api.crawlController.Create(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+func (api *CrawlApi) GetCrawl(ctx *gin.Context) {
+	job, err := api.crawlController.GetById(ctx)
+	api.respondWithJob(ctx, job, err)
+}
+
+func (api *CrawlApi) CancelCrawl(ctx *gin.Context) {
+	job, err := api.crawlController.Cancel(ctx)
+	api.respondWithJob(ctx, job, err)
+}
+
+func (api *CrawlApi) PauseCrawl(ctx *gin.Context) {
+	job, err := api.crawlController.Pause(ctx)
+	api.respondWithJob(ctx, job, err)
+}
+
+func (api *CrawlApi) ResumeCrawl(ctx *gin.Context) {
+	job, err := api.crawlController.Resume(ctx)
+	api.respondWithJob(ctx, job, err)
+}
+
+func (api *CrawlApi) respondWithJob(ctx *gin.Context, job schemas.CrawlJob, err error) {
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, job)
+}
+
+func crawlErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrCrawlJobNotFound), errors.Is(err, service.ErrProjectNotFound),
+		errors.Is(err, service.ErrNoPreviousCrawl):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrIgnoreRobotsHost):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrCrawlJobState):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrCrawlQueueFull):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (api *CrawlApi) GetBrokenLinks(ctx *gin.Context) {
+	brokenLinks, err := api.crawlController.GetBrokenLinks(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, brokenLinks)
+}
+
+func (api *CrawlApi) GetSuspectLinks(ctx *gin.Context) {
+	suspectLinks, err := api.crawlController.GetSuspectLinks(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, suspectLinks)
+}
+
+func (api *CrawlApi) GetSitemapReport(ctx *gin.Context) {
+	report, err := api.crawlController.GetSitemapReport(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+func (api *CrawlApi) GetRedirects(ctx *gin.Context) {
+	redirects, err := api.crawlController.GetRedirects(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, redirects)
+}
+
+func (api *CrawlApi) GetDiff(ctx *gin.Context) {
+	diff, err := api.crawlController.GetDiff(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, diff)
+}
+
+func (api *CrawlApi) CreateStreamTicket(ctx *gin.Context) {
+	ticket, err := api.crawlController.StreamTicket(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, ticket)
+}
+
+// StreamEvents streams the events of a crawl as Server-Sent Events, the
+// stored ones carrying their id for the client to resume after them.
+func (api *CrawlApi) StreamEvents(ctx *gin.Context) {
+	started := false
+	err := api.crawlController.StreamEvents(ctx, ctx.Request.Context(), func(event service.CrawlStreamEvent) error {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		if !started {
+			started = true
+			ctx.Header("Content-Type", "text/event-stream")
+			ctx.Header("Cache-Control", "no-cache")
+			ctx.Header("X-Accel-Buffering", "no")
+			ctx.Status(http.StatusOK)
+		}
+		if event.Id != 0 {
+			fmt.Fprintf(ctx.Writer, "id: %d\n", event.Id)
+		}
+		_, err = fmt.Fprintf(ctx.Writer, "event: %s\ndata: %s\n\n", event.Type, data)
+		ctx.Writer.Flush()
+		return err
+	})
+	if err != nil && !started {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+	}
+}
+
+// crawlWebSocketEvent is an event of a crawl as sent over a WebSocket.
+type crawlWebSocketEvent struct {
+	Id   uint64      `json:"id,omitempty"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// StreamEventsWebSocket streams the events of a crawl over a WebSocket, as
+// JSON messages. The messages sent by the client are ignored.
+func (api *CrawlApi) StreamEventsWebSocket(ctx *gin.Context) {
+	if _, err := api.crawlController.GetById(ctx); err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	server := websocket.Server{
+		// Only the pages of the API and of the frontend may open WebSockets,
+		// the clients without an Origin not being browsers.
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			origin := req.Header.Get("Origin")
+			if origin == "" || api.allowedOrigin(origin, req) {
+				return nil
+			}
+			return errForbiddenOrigin
+		},
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			done, cancel := context.WithCancel(ctx.Request.Context())
+			defer cancel()
+			go func() {
+				// Reading is the only way to notice the client left.
+				io.Copy(io.Discard, conn)
+				cancel()
+			}()
+			err := api.crawlController.StreamEvents(ctx, done, func(event service.CrawlStreamEvent) error {
+				return websocket.JSON.Send(conn, crawlWebSocketEvent{
+					Id:   event.Id,
+					Type: event.Type,
+					Data: event.Data,
+				})
+			})
+			if err != nil && done.Err() == nil {
+				websocket.JSON.Send(conn, crawlWebSocketEvent{
+					Type: "error",
+					Data: &schemas.Response{Message: err.Error()},
+				})
+			}
+		},
+	}
+	server.ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+var errForbiddenOrigin = errors.New("forbidden origin")
+
+// allowedOrigin tells whether a WebSocket may be opened from a page of
+// origin, the one of the API or of the frontend.
+func (api *CrawlApi) allowedOrigin(origin string, req *http.Request) bool {
+	if api.frontendOrigin != "" && origin == api.frontendOrigin {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == req.Host
+}
+
+// crawlExportTypes are the content type and the extension of the files
+// of every export format.
+var crawlExportTypes = map[string][2]string{
+	service.ExportCsv:       {"text/csv; charset=utf-8", "csv"},
+	service.ExportJsonLines: {"application/x-ndjson", "jsonl"},
+	service.ExportJUnit:     {"application/xml; charset=utf-8", "xml"},
+	service.ExportSarif:     {"application/sarif+json", "sarif"},
+}
+
+// ExportCrawl streams the results of a crawl as a file, the response being
+// written as the results are read.
+func (api *CrawlApi) ExportCrawl(ctx *gin.Context) {
+	job, err := api.crawlController.GetById(ctx)
+	if err != nil {
+		ctx.JSON(crawlErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	exportType, found := crawlExportTypes[ctx.Query("format")]
+	if !found {
+		ctx.JSON(http.StatusBadRequest, &schemas.Response{
+			Message: service.ErrExportFormat.Error(),
+		})
+		return
+	}
+	ctx.Header("Content-Type", exportType[0])
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="crawl-%d.%s"`, job.Id, exportType[1]))
+	ctx.Status(http.StatusOK)
+	if err := api.crawlController.Export(ctx, ctx.Writer); err != nil {
+		// Too late to report it, the client gets a truncated file.
+		log.Println("failed to export crawl", job.Id, err)
+	}
+}
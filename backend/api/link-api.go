@@ -63,3 +63,14 @@ func (api *LinkApi) DeleteLink(ctx *gin.Context) {
 		})
 	}
 }
+
+func (api *LinkApi) GetLinkStates(ctx *gin.Context) {
+	states, err := api.linkController.FindStates(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, states)
+}
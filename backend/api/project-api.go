@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Tom-Mendy/SentryLink/controller"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/service"
+)
+
+type ProjectApi struct {
+	projectController controller.ProjectController
+}
+
+func NewProjectAPI(projectController controller.ProjectController) *ProjectApi {
+	return &ProjectApi{
+		projectController: projectController,
+	}
+}
+
+func (api *ProjectApi) GetProjects(ctx *gin.Context) {
+	projects, err := api.projectController.FindAll(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, projects)
+}
+
+func (api *ProjectApi) CreateProject(ctx *gin.Context) {
+	project, err := api.projectController.Save(ctx)
+	if err != nil {
+		ctx.JSON(projectErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, project)
+}
+
+func (api *ProjectApi) UpdateProject(ctx *gin.Context) {
+	err := api.projectController.Update(ctx)
+	if err != nil {
+		ctx.JSON(projectErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, &schemas.Response{
+			Message: "Success!",
+		})
+	}
+}
+
+func (api *ProjectApi) DeleteProject(ctx *gin.Context) {
+	err := api.projectController.Delete(ctx)
+	if err != nil {
+		ctx.JSON(projectErrorStatus(err), &schemas.Response{
+			Message: err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, &schemas.Response{
+			Message: "Success!",
+		})
+	}
+}
+
+func projectErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrProjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrIgnoreRobotsForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -1,11 +1,13 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/Tom-Mendy/SentryLink/controller"
+	"github.com/Tom-Mendy/SentryLink/service"
 )
 
 type ScrapApi struct {
@@ -19,5 +21,15 @@ func NewScrapApi(scrapController controller.ScrapController) *ScrapApi {
 }
 
 func (api *ScrapApi) GetScrappedUrl(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, api.scrapController.Scrap(ctx))
-}
\ No newline at end of file
+	links, err := api.scrapController.Scrap(ctx)
+	if err != nil {
+		var fetchErr *service.FetchError
+		if errors.As(err, &fetchErr) {
+			ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		} else {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	ctx.JSON(http.StatusOK, links)
+}
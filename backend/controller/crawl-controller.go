@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/service"
+)
+
+type CrawlController interface {
+	Create(ctx *gin.Context) (schemas.CrawlJob, error)
+	GetById(ctx *gin.Context) (schemas.CrawlJob, error)
+	GetBrokenLinks(ctx *gin.Context) ([]schemas.BrokenLink, error)
+	GetSuspectLinks(ctx *gin.Context) ([]schemas.BrokenLink, error)
+	GetSitemapReport(ctx *gin.Context) (schemas.SitemapReport, error)
+	GetRedirects(ctx *gin.Context) ([]schemas.RedirectReport, error)
+	GetDiff(ctx *gin.Context) (schemas.CrawlDiff, error)
+	Cancel(ctx *gin.Context) (schemas.CrawlJob, error)
+	Pause(ctx *gin.Context) (schemas.CrawlJob, error)
+	Resume(ctx *gin.Context) (schemas.CrawlJob, error)
+	StreamTicket(ctx *gin.Context) (schemas.CrawlStreamTicket, error)
+	StreamEvents(ctx *gin.Context, done context.Context, send func(event service.CrawlStreamEvent) error) error
+	Export(ctx *gin.Context, w io.Writer) error
+}
+
+var errInvalidLastEventId = errors.New("invalid last event id")
+
+type crawlController struct {
+	service      service.CrawlJobService
+	eventService service.CrawlEventService
+	serviceJWT   service.JWTService
+}
+
+func NewCrawlController(crawlJobService service.CrawlJobService, crawlEventService service.CrawlEventService, jwtService service.JWTService) CrawlController {
+	return &crawlController{
+		service:      crawlJobService,
+		eventService: crawlEventService,
+		serviceJWT:   jwtService,
+	}
+}
+
+func (controller *crawlController) Create(ctx *gin.Context) (schemas.CrawlJob, error) {
+	user, err := requestUser(ctx, controller.serviceJWT)
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	var request schemas.CrawlRequest
+	err = ctx.ShouldBindJSON(&request)
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return controller.service.Enqueue(user, request)
+}
+
+func (controller *crawlController) GetById(ctx *gin.Context) (schemas.CrawlJob, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return controller.service.GetJob(user, id)
+}
+
+func (controller *crawlController) GetBrokenLinks(ctx *gin.Context) ([]schemas.BrokenLink, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return controller.service.GetBrokenLinks(user, id)
+}
+
+func (controller *crawlController) GetSuspectLinks(ctx *gin.Context) ([]schemas.BrokenLink, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return controller.service.GetSuspectLinks(user, id)
+}
+
+func (controller *crawlController) GetSitemapReport(ctx *gin.Context) (schemas.SitemapReport, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return schemas.SitemapReport{}, err
+	}
+	return controller.service.GetSitemapReport(user, id)
+}
+
+func (controller *crawlController) GetRedirects(ctx *gin.Context) ([]schemas.RedirectReport, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return controller.service.GetRedirects(user, id)
+}
+
+// GetDiff compares a crawl with the one given by the against query
+// parameter, the previous run of its site by default.
+func (controller *crawlController) GetDiff(ctx *gin.Context) (schemas.CrawlDiff, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return schemas.CrawlDiff{}, err
+	}
+	var against uint64
+	if value := ctx.Query("against"); value != "" {
+		against, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return schemas.CrawlDiff{}, service.ErrCrawlJobNotFound
+		}
+	}
+	return controller.service.GetDiff(user, id, against)
+}
+
+func (controller *crawlController) Cancel(ctx *gin.Context) (schemas.CrawlJob, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return controller.service.Cancel(user, id)
+}
+
+func (controller *crawlController) Pause(ctx *gin.Context) (schemas.CrawlJob, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return controller.service.Pause(user, id)
+}
+
+func (controller *crawlController) Resume(ctx *gin.Context) (schemas.CrawlJob, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return controller.service.Resume(user, id)
+}
+
+// StreamTicket issues a ticket opening the event stream of a crawl to the
+// user of the token of the request.
+func (controller *crawlController) StreamTicket(ctx *gin.Context) (schemas.CrawlStreamTicket, error) {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return schemas.CrawlStreamTicket{}, err
+	}
+	job, err := controller.service.GetJob(user, id)
+	if err != nil {
+		return schemas.CrawlStreamTicket{}, err
+	}
+	ticket, expiresAt := controller.serviceJWT.GenerateStreamTicket(strconv.FormatUint(user.Id, 10), job.Id)
+	return schemas.CrawlStreamTicket{Ticket: ticket, ExpiresAt: expiresAt}, nil
+}
+
+// StreamEvents sends the events of a crawl until done, resuming after the
+// event given by the Last-Event-ID header or the last_event_id query
+// parameter.
+func (controller *crawlController) StreamEvents(ctx *gin.Context, done context.Context, send func(event service.CrawlStreamEvent) error) error {
+	id, err := crawlJobId(ctx)
+	if err != nil {
+		return err
+	}
+	// Without a token, the request has a ticket the middleware checked,
+	// which is only issued to the users who may see the crawl.
+	if ctx.GetHeader("Authorization") != "" {
+		user, err := requestUser(ctx, controller.serviceJWT)
+		if err != nil {
+			return err
+		}
+		if _, err := controller.service.GetJob(user, id); err != nil {
+			return err
+		}
+	}
+	lastEventId := ctx.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = ctx.Query("last_event_id")
+	}
+	var after uint64
+	if lastEventId != "" {
+		after, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			return errInvalidLastEventId
+		}
+	}
+	return controller.eventService.Stream(done, id, after, send)
+}
+
+// Export writes the results of a crawl to w in the format given by the
+// format query parameter, only the broken ones when broken is true.
+func (controller *crawlController) Export(ctx *gin.Context, w io.Writer) error {
+	user, id, err := controller.crawlJob(ctx)
+	if err != nil {
+		return err
+	}
+	brokenOnly, _ := strconv.ParseBool(ctx.Query("broken"))
+	return controller.service.Export(user, id, ctx.Query("format"), brokenOnly, w)
+}
+
+// crawlJob returns the user of the request along with the id of the crawl it
+// is about.
+func (controller *crawlController) crawlJob(ctx *gin.Context) (service.RequestUser, uint64, error) {
+	user, err := requestUser(ctx, controller.serviceJWT)
+	if err != nil {
+		return service.RequestUser{}, 0, err
+	}
+	id, err := crawlJobId(ctx)
+	return user, id, err
+}
+
+func crawlJobId(ctx *gin.Context) (uint64, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return 0, service.ErrCrawlJobNotFound
+	}
+	return id, nil
+}
@@ -16,18 +16,21 @@ type LinkController interface {
 	Update(ctx *gin.Context) error
 	Delete(ctx *gin.Context) error
 	ShowAll(ctx *gin.Context)
+	FindStates(ctx *gin.Context) ([]schemas.LinkState, error)
 }
 
 type linkController struct {
-	service service.LinkService
+	service    service.LinkService
+	linkStates service.LinkStateService
 }
 
 var validateLink *validator.Validate
 
-func NewLinkController(service service.LinkService) LinkController {
+func NewLinkController(service service.LinkService, linkStateService service.LinkStateService) LinkController {
 	validateLink = validator.New()
 	return &linkController{
-		service: service,
+		service:    service,
+		linkStates: linkStateService,
 	}
 }
 
@@ -100,3 +103,14 @@ func (c *linkController) ShowAll(ctx *gin.Context) {
 	}
 	ctx.HTML(http.StatusOK, "index.html", data)
 }
+
+// FindStates returns the states of the URLs, only the ones in the state
+// given by the query when there is one.
+func (c *linkController) FindStates(ctx *gin.Context) ([]schemas.LinkState, error) {
+	state := ctx.Query("state")
+	err := validateLink.Var(state, "omitempty,oneof=ok suspect broken recovered")
+	if err != nil {
+		return nil, err
+	}
+	return c.linkStates.FindByState(state), nil
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/service"
+)
+
+type ProjectController interface {
+	FindAll(ctx *gin.Context) ([]schemas.Project, error)
+	Save(ctx *gin.Context) (schemas.Project, error)
+	Update(ctx *gin.Context) error
+	Delete(ctx *gin.Context) error
+}
+
+type projectController struct {
+	service    service.ProjectService
+	serviceJWT service.JWTService
+}
+
+func NewProjectController(projectService service.ProjectService, jwtService service.JWTService) ProjectController {
+	return &projectController{
+		service:    projectService,
+		serviceJWT: jwtService,
+	}
+}
+
+func (controller *projectController) FindAll(ctx *gin.Context) ([]schemas.Project, error) {
+	user, err := requestUser(ctx, controller.serviceJWT)
+	if err != nil {
+		return nil, err
+	}
+	return controller.service.FindAll(user), nil
+}
+
+func (controller *projectController) Save(ctx *gin.Context) (schemas.Project, error) {
+	user, err := requestUser(ctx, controller.serviceJWT)
+	if err != nil {
+		return schemas.Project{}, err
+	}
+	var project schemas.Project
+	err = ctx.ShouldBindJSON(&project)
+	if err != nil {
+		return schemas.Project{}, err
+	}
+	project.Id = 0
+	return controller.service.Save(user, project)
+}
+
+func (controller *projectController) Update(ctx *gin.Context) error {
+	user, err := requestUser(ctx, controller.serviceJWT)
+	if err != nil {
+		return err
+	}
+	var project schemas.Project
+	err = ctx.ShouldBindJSON(&project)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return err
+	}
+	project.Id = id
+	return controller.service.Update(user, project)
+}
+
+func (controller *projectController) Delete(ctx *gin.Context) error {
+	user, err := requestUser(ctx, controller.serviceJWT)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return err
+	}
+	return controller.service.Delete(user, id)
+}
+
+// requestUser returns the user of the JWT the request was authorized with.
+func requestUser(ctx *gin.Context, jwtService service.JWTService) (service.RequestUser, error) {
+	const BEARER_SCHEMA = "Bearer "
+	authHeader := ctx.GetHeader("Authorization")
+	if !strings.HasPrefix(authHeader, BEARER_SCHEMA) {
+		return service.RequestUser{}, errors.New("missing bearer token")
+	}
+	tokenString := authHeader[len(BEARER_SCHEMA):]
+
+	userId, err := jwtService.GetUserIdfromJWTToken(tokenString)
+	if err != nil {
+		return service.RequestUser{}, err
+	}
+	admin, err := jwtService.GetAdminfromJWTToken(tokenString)
+	if err != nil {
+		return service.RequestUser{}, err
+	}
+	return service.RequestUser{Id: userId, Admin: admin}, nil
+}
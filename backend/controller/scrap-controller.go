@@ -1,12 +1,7 @@
 package controller
 
 import (
-	"fmt"
-	"log"
-	"net/http"
-	"net/url"
-
-	"golang.org/x/net/html"
+	"errors"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -15,7 +10,7 @@ import (
 )
 
 type ScrapController interface {
-	Scrap(ctx *gin.Context) []string
+	Scrap(ctx *gin.Context) ([]string, error)
 }
 
 type scrapController struct {
@@ -31,58 +26,11 @@ func NewScrapController(scrapService service.ScrapService) ScrapController {
 	}
 }
 
-
-func ExtractLinks(pageURL string) ([]string, error) {
-	resp, err := http.Get(pageURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch the page: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch the page: %s", resp.Status)
-	}
-
-	links := []string{}
-	tokenizer := html.NewTokenizer(resp.Body)
-	for {
-		tokenType := tokenizer.Next()
-		if tokenType == html.ErrorToken {
-			break
-		}
-		if tokenType == html.StartTagToken {
-			token := tokenizer.Token()
-			if token.Data == "a" {
-				for _, attr := range token.Attr {
-					if attr.Key == "href" {
-						// Resolve relative URLs
-						parsedURL, err := url.Parse(attr.Val)
-						if err != nil {
-							continue
-						}
-						base, err := url.Parse(pageURL)
-						if err != nil {
-							continue
-						}
-						links = append(links, base.ResolveReference(parsedURL).String())
-					}
-				}
-			}
-		}
-	}
-
-	return links, nil
-}
-
-func (controller *scrapController) Scrap(ctx *gin.Context) []string {
+func (controller *scrapController) Scrap(ctx *gin.Context) ([]string, error) {
 	pageURL := ctx.Query("url")
-	links, err := ExtractLinks(pageURL)
+	err := validateScrap.Var(pageURL, "required,url")
 	if err != nil {
-		log.Println("Error:", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-
+		return nil, errors.New("url must be a valid absolute URL")
 	}
-
-	return links
-	// ctx.JSON(http.StatusOK, gin.H{"links": links})
-}
\ No newline at end of file
+	return controller.service.Scrap(ctx.Request.Context(), pageURL)
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -72,6 +76,7 @@ func setupRouter(deps Dependencies) *gin.Engine {
 		links := apiRoutes.Group("/links", middlewares.AuthorizeJWT())
 		{
 			links.GET("", deps.LinkAPI.GetLink)
+			links.GET("states", deps.LinkAPI.GetLinkStates)
 			links.POST("", deps.LinkAPI.CreateLink)
 			links.PUT(":id", deps.LinkAPI.UpdateLink)
 			links.DELETE(":id", deps.LinkAPI.DeleteLink)
@@ -83,6 +88,39 @@ func setupRouter(deps Dependencies) *gin.Engine {
 			scrap.GET("", deps.ScrapAPI.GetScrappedUrl)
 		}
 
+		// Projects
+		projects := apiRoutes.Group("/projects", middlewares.AuthorizeJWT())
+		{
+			projects.GET("", deps.ProjectAPI.GetProjects)
+			projects.POST("", deps.ProjectAPI.CreateProject)
+			projects.PUT(":id", deps.ProjectAPI.UpdateProject)
+			projects.DELETE(":id", deps.ProjectAPI.DeleteProject)
+		}
+
+		// Crawls
+		crawls := apiRoutes.Group("/crawls", middlewares.AuthorizeJWT())
+		{
+			crawls.POST("", deps.CrawlAPI.CreateCrawl)
+			crawls.GET(":id", deps.CrawlAPI.GetCrawl)
+			crawls.GET(":id/broken-links", deps.CrawlAPI.GetBrokenLinks)
+			crawls.GET(":id/suspect-links", deps.CrawlAPI.GetSuspectLinks)
+			crawls.GET(":id/sitemap", deps.CrawlAPI.GetSitemapReport)
+			crawls.GET(":id/redirects", deps.CrawlAPI.GetRedirects)
+			crawls.GET(":id/diff", deps.CrawlAPI.GetDiff)
+			crawls.GET(":id/export", deps.CrawlAPI.ExportCrawl)
+			crawls.POST(":id/events/ticket", deps.CrawlAPI.CreateStreamTicket)
+			crawls.DELETE(":id", deps.CrawlAPI.CancelCrawl)
+			crawls.POST(":id/cancel", deps.CrawlAPI.CancelCrawl)
+			crawls.POST(":id/pause", deps.CrawlAPI.PauseCrawl)
+			crawls.POST(":id/resume", deps.CrawlAPI.ResumeCrawl)
+		}
+		// Live events, browsers passing a stream ticket as a query parameter
+		crawlEvents := apiRoutes.Group("/crawls", middlewares.AuthorizeStreamJWT())
+		{
+			crawlEvents.GET(":id/events", deps.CrawlAPI.StreamEvents)
+			crawlEvents.GET(":id/ws", deps.CrawlAPI.StreamEventsWebSocket)
+		}
+
 		// Github
 		github := apiRoutes.Group("/github")
 		{
@@ -112,10 +150,12 @@ func setupRouter(deps Dependencies) *gin.Engine {
 }
 
 type Dependencies struct {
-	UserAPI   *api.UserApi
-	LinkAPI   *api.LinkApi
-	ScrapAPI  *api.ScrapApi
-	GithubAPI *api.GithubApi
+	UserAPI    *api.UserApi
+	LinkAPI    *api.LinkApi
+	ScrapAPI   *api.ScrapApi
+	GithubAPI  *api.GithubApi
+	CrawlAPI   *api.CrawlApi
+	ProjectAPI *api.ProjectApi
 }
 
 // initDependencies initializes all required dependencies
@@ -128,29 +168,75 @@ func initDependencies() Dependencies {
 	githubTokenRepository := repository.NewGithubTokenRepository(databaseConnection)
 	userRepository := repository.NewUserRepository(databaseConnection)
 	scrapRepository := repository.NewScrapRepository(databaseConnection)
+	crawlJobRepository := repository.NewCrawlJobRepository(databaseConnection)
+	crawlEdgeRepository := repository.NewCrawlEdgeRepository(databaseConnection)
+	crawlEventRepository := repository.NewCrawlEventRepository(databaseConnection)
+	crawlFrontierRepository := repository.NewCrawlFrontierRepository(databaseConnection)
+	crawlSitemapRepository := repository.NewCrawlSitemapRepository(databaseConnection)
+	crawlVisitedRepository := repository.NewCrawlVisitedRepository(databaseConnection)
+	projectRepository := repository.NewProjectRepository(databaseConnection)
+	linkStateRepository := repository.NewLinkStateRepository(databaseConnection)
+	// The crawls are run by the workers when CRAWL_MODE is distributed.
+	var crawlWorkRepository repository.CrawlWorkRepository
+	if os.Getenv("CRAWL_MODE") == "distributed" {
+		crawlWorkRepository = repository.NewCrawlWorkRepository(databaseConnection)
+	}
+
+	// Fetcher shared by the scrap endpoint and the crawler
+	fetcher := service.NewHttpFetcher()
 
 	// Services
 	linkService := service.NewLinkService(linkRepository)
 	githubTokenService := service.NewGithubTokenService(githubTokenRepository)
 	jwtService := service.NewJWTService()
 	userService := service.NewUserService(userRepository, jwtService)
-	scrapService := service.NewScrapService(scrapRepository)
+	scrapService := service.NewScrapService(scrapRepository, fetcher)
+	linkStateService := service.NewLinkStateService(linkStateRepository)
+	crawlJobService := service.NewCrawlJobService(crawlJobRepository, linkRepository, crawlEdgeRepository, crawlEventRepository, crawlFrontierRepository, crawlSitemapRepository, crawlVisitedRepository, crawlWorkRepository, projectRepository, linkStateService, fetcher)
+	crawlEventService := service.NewCrawlEventService(crawlEventRepository, crawlJobService)
+	projectService := service.NewProjectService(projectRepository)
 
 	// Controllers
-	linkController := controller.NewLinkController(linkService)
+	linkController := controller.NewLinkController(linkService, linkStateService)
 	githubTokenController := controller.NewGithubTokenController(githubTokenService, userService)
 	userController := controller.NewUserController(userService, jwtService)
 	scrapController := controller.NewScrapController(scrapService)
+	crawlController := controller.NewCrawlController(crawlJobService, crawlEventService, jwtService)
+	projectController := controller.NewProjectController(projectService, jwtService)
 
 	// APIs
 	return Dependencies{
-		UserAPI:   api.NewUserAPI(userController),
-		LinkAPI:   api.NewLinkAPI(linkController),
-		ScrapAPI:  api.NewScrapApi(scrapController),
-		GithubAPI: api.NewGithubAPI(githubTokenController),
+		UserAPI:    api.NewUserAPI(userController),
+		LinkAPI:    api.NewLinkAPI(linkController),
+		ScrapAPI:   api.NewScrapApi(scrapController),
+		GithubAPI:  api.NewGithubAPI(githubTokenController),
+		CrawlAPI:   api.NewCrawlAPI(crawlController),
+		ProjectAPI: api.NewProjectAPI(projectController),
 	}
 }
 
+// runCrawlWorker fetches the URLs of the crawls queued by the API instances
+// until interrupted, in place of serving the API.
+func runCrawlWorker() {
+	databaseConnection := database.Connection()
+
+	linkRepository := repository.NewLinkRepository(databaseConnection)
+	crawlJobRepository := repository.NewCrawlJobRepository(databaseConnection)
+	crawlEdgeRepository := repository.NewCrawlEdgeRepository(databaseConnection)
+	crawlEventRepository := repository.NewCrawlEventRepository(databaseConnection)
+	crawlWorkRepository := repository.NewCrawlWorkRepository(databaseConnection)
+	crawlHostRepository := repository.NewCrawlHostRepository(databaseConnection)
+	linkStateRepository := repository.NewLinkStateRepository(databaseConnection)
+
+	linkStateService := service.NewLinkStateService(linkStateRepository)
+	concurrency, _ := strconv.Atoi(os.Getenv("CRAWL_WORKER_CONCURRENCY"))
+	worker := service.NewCrawlWorker(crawlWorkRepository, crawlHostRepository, crawlJobRepository, linkRepository, crawlEdgeRepository, crawlEventRepository, linkStateService, service.NewHttpFetcher(), concurrency)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	worker.Run(ctx)
+}
+
 // initRoutes initializes custom routes (e.g., Swagger routes)
 func initRoutes(deps Dependencies) {
 	var routes = []schemas.Route{
@@ -226,6 +312,10 @@ func handleAction(mychannel chan ActionService, active *bool) {
 // @in header
 // @name Authorization.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		runCrawlWorker()
+		return
+	}
 
 	deps := initDependencies()
 
@@ -3,6 +3,8 @@ package middlewares
 import (
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Tom-Mendy/SentryLink/service"
 	"github.com/gin-gonic/gin"
@@ -11,14 +13,42 @@ import (
 
 // AuthorizeJWT validates the token from the http request, returning a 401 if it's not valid.
 func AuthorizeJWT() gin.HandlerFunc {
+	return authorizeJWT(false)
+}
+
+// AuthorizeStreamJWT is AuthorizeJWT also accepting, in the ticket query
+// parameter, a stream ticket of the crawl of the id path parameter, browsers
+// not letting EventSource and WebSocket set headers. Unlike the token, the
+// ticket ending up in the access logs expires quickly and opens nothing else.
+func AuthorizeStreamJWT() gin.HandlerFunc {
+	return authorizeJWT(true)
+}
+
+func authorizeJWT(allowTicket bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		const BEARER_SCHEMA = "Bearer "
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && allowTicket {
+			crawlJobId, err := strconv.ParseUint(c.Param("id"), 10, 64)
+			if err == nil {
+				err = service.NewJWTService().ValidateStreamTicket(c.Query("ticket"), crawlJobId)
+			}
+			if err != nil {
+				log.Println(err)
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+			return
+		}
+		if !strings.HasPrefix(authHeader, BEARER_SCHEMA) {
+			log.Println("missing bearer token")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 		tokenString := authHeader[len(BEARER_SCHEMA):]
 
 		token, err := service.NewJWTService().ValidateToken(tokenString)
 
-		if token.Valid {
+		if err == nil && token.Valid {
 			claims := token.Claims.(jwt.MapClaims)
 			log.Println("Claims: ", claims)
 			log.Println("Claims[Id]: ", claims["jti"])
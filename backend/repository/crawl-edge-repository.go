@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type CrawlEdgeRepository interface {
+	SaveAll(edges []schemas.CrawlEdge)
+	FindByTargets(crawlJobId uint64, targetUrls []string) []schemas.CrawlEdge
+	FindWithFragment(crawlJobId uint64) []schemas.CrawlEdge
+	FindBySource(crawlJobId uint64, sourceUrl string) []schemas.CrawlEdge
+}
+
+type crawlEdgeRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlEdgeRepository(conn *gorm.DB) CrawlEdgeRepository {
+	err := conn.AutoMigrate(&schemas.CrawlEdge{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlEdgeRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *crawlEdgeRepository) SaveAll(edges []schemas.CrawlEdge) {
+	if len(edges) == 0 {
+		return
+	}
+	err := repo.db.Connection.CreateInBatches(&edges, 500)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlEdgeRepository) FindByTargets(crawlJobId uint64, targetUrls []string) []schemas.CrawlEdge {
+	var edges []schemas.CrawlEdge
+	for start := 0; start < len(targetUrls); start += 500 {
+		var batch []schemas.CrawlEdge
+		err := repo.db.Connection.
+			Where("crawl_job_id = ? AND target_url IN ?", crawlJobId, targetUrls[start:min(start+500, len(targetUrls))]).
+			Order("id").Find(&batch)
+		if err.Error != nil {
+			panic(err.Error)
+		}
+		edges = append(edges, batch...)
+	}
+	return edges
+}
+
+func (repo *crawlEdgeRepository) FindWithFragment(crawlJobId uint64) []schemas.CrawlEdge {
+	var edges []schemas.CrawlEdge
+	err := repo.db.Connection.Where("crawl_job_id = ? AND fragment <> ''", crawlJobId).Order("id").Find(&edges)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return edges
+}
+
+func (repo *crawlEdgeRepository) FindBySource(crawlJobId uint64, sourceUrl string) []schemas.CrawlEdge {
+	var edges []schemas.CrawlEdge
+	err := repo.db.Connection.Where(&schemas.CrawlEdge{CrawlJobId: crawlJobId, SourceUrl: sourceUrl}).Order("id").Find(&edges)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return edges
+}
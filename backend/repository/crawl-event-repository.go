@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type CrawlEventRepository interface {
+	SaveAll(events []schemas.CrawlEvent)
+	FindAfter(crawlJobId uint64, afterSequence uint64, limit int) []schemas.CrawlEvent
+	DeleteFinished(grace time.Duration)
+}
+
+type crawlEventRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlEventRepository(conn *gorm.DB) CrawlEventRepository {
+	err := conn.AutoMigrate(&schemas.CrawlEvent{}, &schemas.CrawlEventSequence{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlEventRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+// SaveAll numbers the events of a crawl and stores them. The sequence of
+// the crawl stays locked until they are, so that the events are visible in
+// the order of their numbers.
+func (repo *crawlEventRepository) SaveAll(events []schemas.CrawlEvent) {
+	if len(events) == 0 {
+		return
+	}
+	err := repo.db.Connection.Transaction(func(tx *gorm.DB) error {
+		var last uint64
+		err := tx.Raw(`
+			INSERT INTO crawl_event_sequences (crawl_job_id, last) VALUES (?, ?)
+			ON CONFLICT (crawl_job_id) DO UPDATE SET last = crawl_event_sequences.last + excluded.last
+			RETURNING last`,
+			events[0].CrawlJobId, len(events)).Scan(&last)
+		if err.Error != nil {
+			return err.Error
+		}
+		for i := range events {
+			events[i].Sequence = last - uint64(len(events)-1-i)
+		}
+		return tx.Create(&events).Error
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindAfter returns the first events of a crawl following the one numbered
+// afterSequence, in order.
+func (repo *crawlEventRepository) FindAfter(crawlJobId uint64, afterSequence uint64, limit int) []schemas.CrawlEvent {
+	var events []schemas.CrawlEvent
+	err := repo.db.Connection.Where("crawl_job_id = ? AND sequence > ?", crawlJobId, afterSequence).Order("sequence").Limit(limit).Find(&events)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return events
+}
+
+// DeleteFinished deletes the events of the crawls finished for longer than
+// grace, which no client needs to catch up with anymore.
+func (repo *crawlEventRepository) DeleteFinished(grace time.Duration) {
+	finished := repo.db.Connection.Model(&schemas.CrawlJob{}).Select("id").Where("finished_at < ?", time.Now().Add(-grace))
+	err := repo.db.Connection.Where("crawl_job_id IN (?)", finished).Delete(&schemas.CrawlEvent{})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	err = repo.db.Connection.Where("crawl_job_id IN (?)", finished).Delete(&schemas.CrawlEventSequence{})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
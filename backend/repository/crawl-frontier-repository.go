@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type CrawlFrontierRepository interface {
+	SaveAll(items []schemas.CrawlFrontierItem)
+	FindByCrawlJob(crawlJobId uint64) []schemas.CrawlFrontierItem
+	DeleteByCrawlJob(crawlJobId uint64)
+}
+
+type crawlFrontierRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlFrontierRepository(conn *gorm.DB) CrawlFrontierRepository {
+	err := conn.AutoMigrate(&schemas.CrawlFrontierItem{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlFrontierRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *crawlFrontierRepository) SaveAll(items []schemas.CrawlFrontierItem) {
+	if len(items) == 0 {
+		return
+	}
+	err := repo.db.Connection.CreateInBatches(&items, 500)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlFrontierRepository) FindByCrawlJob(crawlJobId uint64) []schemas.CrawlFrontierItem {
+	var items []schemas.CrawlFrontierItem
+	err := repo.db.Connection.Where(&schemas.CrawlFrontierItem{CrawlJobId: crawlJobId}).Order("id").Find(&items)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return items
+}
+
+func (repo *crawlFrontierRepository) DeleteByCrawlJob(crawlJobId uint64) {
+	err := repo.db.Connection.Where(&schemas.CrawlFrontierItem{CrawlJobId: crawlJobId}).Delete(&schemas.CrawlFrontierItem{})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+// CrawlHostRepository schedules the requests the crawl workers make to every
+// host, the database clock being the only one they share.
+type CrawlHostRepository interface {
+	// Reserve books the next request to host, interval after the previous
+	// one, returning how long to wait before making it.
+	Reserve(host string, interval time.Duration) time.Duration
+	// Block keeps the requests to host from starting before wait elapsed.
+	Block(host string, wait time.Duration)
+	// DeleteIdle forgets the hosts not requested for idle.
+	DeleteIdle(idle time.Duration)
+}
+
+type crawlHostRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlHostRepository(conn *gorm.DB) CrawlHostRepository {
+	err := conn.AutoMigrate(&schemas.CrawlHostSlot{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlHostRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *crawlHostRepository) Reserve(host string, interval time.Duration) time.Duration {
+	var wait float64
+	// The row lock taken by the upsert orders the workers booking a host.
+	err := repo.db.Connection.Raw(`
+		INSERT INTO crawl_host_slots (host, next_at) VALUES (?, now() + ? * interval '1 microsecond')
+		ON CONFLICT (host) DO UPDATE SET next_at = GREATEST(crawl_host_slots.next_at, now()) + ? * interval '1 microsecond'
+		RETURNING EXTRACT(EPOCH FROM next_at - now())`,
+		host, interval.Microseconds(), interval.Microseconds()).Scan(&wait)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return max(time.Duration(wait*float64(time.Second))-interval, 0)
+}
+
+func (repo *crawlHostRepository) Block(host string, wait time.Duration) {
+	err := repo.db.Connection.Exec(`
+		INSERT INTO crawl_host_slots (host, next_at) VALUES (?, now() + ? * interval '1 microsecond')
+		ON CONFLICT (host) DO UPDATE SET next_at = GREATEST(crawl_host_slots.next_at, excluded.next_at)`,
+		host, wait.Microseconds())
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlHostRepository) DeleteIdle(idle time.Duration) {
+	err := repo.db.Connection.Where("next_at < now() - ? * interval '1 microsecond'", idle.Microseconds()).Delete(&schemas.CrawlHostSlot{})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+// ErrNotFound is returned by the lookups that do not panic when no row matches.
+var ErrNotFound = errors.New("record not found")
+
+type CrawlJobRepository interface {
+	Save(job schemas.CrawlJob) (jobId uint64)
+	UpdateProgress(id uint64, progress schemas.CrawlProgress)
+	AddProgress(id uint64, progress schemas.CrawlProgress)
+	UpdateError(id uint64, message string)
+	UpdateTraps(id uint64, update func(traps []schemas.CrawlTrap) []schemas.CrawlTrap)
+	UpdateStatus(id uint64, from string, to string) bool
+	Start(id uint64, startedAt time.Time) bool
+	Finish(id uint64, status string, finishedAt time.Time, message string) bool
+	FinishRunning(id uint64, status string, finishedAt time.Time) bool
+	FindById(id uint64) (schemas.CrawlJob, error)
+	FindByStatus(status string) []schemas.CrawlJob
+	FindPreviousRun(job schemas.CrawlJob) (schemas.CrawlJob, error)
+}
+
+type crawlJobRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlJobRepository(conn *gorm.DB) CrawlJobRepository {
+	err := conn.AutoMigrate(&schemas.CrawlJob{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlJobRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *crawlJobRepository) Save(job schemas.CrawlJob) (jobId uint64) {
+	err := repo.db.Connection.Create(&job)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return job.Id
+}
+
+func (repo *crawlJobRepository) UpdateProgress(id uint64, progress schemas.CrawlProgress) {
+	err := repo.db.Connection.Model(&schemas.CrawlJob{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"pages_fetched":   progress.PagesFetched,
+		"broken_links":    progress.BrokenLinks,
+		"suspect_links":   progress.SuspectLinks,
+		"pages_skipped":   progress.PagesSkipped,
+		"pages_unchanged": progress.PagesUnchanged,
+	})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+// AddProgress adds to the counters of a job, which several workers update.
+func (repo *crawlJobRepository) AddProgress(id uint64, progress schemas.CrawlProgress) {
+	err := repo.db.Connection.Model(&schemas.CrawlJob{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"pages_fetched":   gorm.Expr("pages_fetched + ?", progress.PagesFetched),
+		"broken_links":    gorm.Expr("broken_links + ?", progress.BrokenLinks),
+		"suspect_links":   gorm.Expr("suspect_links + ?", progress.SuspectLinks),
+		"pages_skipped":   gorm.Expr("pages_skipped + ?", progress.PagesSkipped),
+		"pages_unchanged": gorm.Expr("pages_unchanged + ?", progress.PagesUnchanged),
+	})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlJobRepository) UpdateError(id uint64, message string) {
+	err := repo.db.Connection.Model(&schemas.CrawlJob{}).Where("id = ?", id).Update("error", message)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+// UpdateTraps replaces the traps of a job by the ones returned by update,
+// the job being locked meanwhile.
+func (repo *crawlJobRepository) UpdateTraps(id uint64, update func(traps []schemas.CrawlTrap) []schemas.CrawlTrap) {
+	err := repo.db.Connection.Transaction(func(tx *gorm.DB) error {
+		var job schemas.CrawlJob
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "traps").Where("id = ?", id).First(&job)
+		if err.Error != nil {
+			return err.Error
+		}
+		job.Traps = update(job.Traps)
+		return tx.Model(&job).Select("traps").Updates(&job).Error
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// UpdateStatus changes the status of a job unless it is no longer from,
+// telling whether it was.
+func (repo *crawlJobRepository) UpdateStatus(id uint64, from string, to string) bool {
+	err := repo.db.Connection.Model(&schemas.CrawlJob{}).
+		Where("id = ? AND status = ?", id, from).
+		Update("status", to)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return err.RowsAffected > 0
+}
+
+// Start marks a job as running unless it is no longer queued, telling
+// whether it was. The start of a resumed job is kept.
+func (repo *crawlJobRepository) Start(id uint64, startedAt time.Time) bool {
+	err := repo.db.Connection.Model(&schemas.CrawlJob{}).
+		Where("id = ? AND status = ?", id, schemas.CrawlJobQueued).
+		Updates(map[string]interface{}{
+			"status":     schemas.CrawlJobRunning,
+			"started_at": gorm.Expr("COALESCE(started_at, ?)", startedAt),
+		})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return err.RowsAffected > 0
+}
+
+// Finish sets the final status of a job, along with message unless empty,
+// unless it is already finished, telling whether it was not.
+func (repo *crawlJobRepository) Finish(id uint64, status string, finishedAt time.Time, message string) bool {
+	columns := map[string]interface{}{"status": status, "finished_at": finishedAt}
+	if message != "" {
+		columns["error"] = message
+	}
+	err := repo.db.Connection.Model(&schemas.CrawlJob{}).
+		Where("id = ? AND status NOT IN ?", id, []string{schemas.CrawlJobSucceeded, schemas.CrawlJobFailed, schemas.CrawlJobCancelled}).
+		Updates(columns)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return err.RowsAffected > 0
+}
+
+// FinishRunning sets the final status of a job unless it is no longer
+// running, telling whether it was.
+func (repo *crawlJobRepository) FinishRunning(id uint64, status string, finishedAt time.Time) bool {
+	err := repo.db.Connection.Model(&schemas.CrawlJob{}).
+		Where("id = ? AND status = ?", id, schemas.CrawlJobRunning).
+		Updates(map[string]interface{}{"status": status, "finished_at": finishedAt})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return err.RowsAffected > 0
+}
+
+func (repo *crawlJobRepository) FindById(id uint64) (schemas.CrawlJob, error) {
+	var job schemas.CrawlJob
+	err := repo.db.Connection.Where("id = ?", id).First(&job)
+	if errors.Is(err.Error, gorm.ErrRecordNotFound) {
+		return job, ErrNotFound
+	}
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return job, nil
+}
+
+func (repo *crawlJobRepository) FindByStatus(status string) []schemas.CrawlJob {
+	var jobs []schemas.CrawlJob
+	err := repo.db.Connection.Where("status = ?", status).Order("id").Find(&jobs)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return jobs
+}
+
+// FindPreviousRun returns the last crawl of the same site, project and owner
+// which succeeded before job.
+func (repo *crawlJobRepository) FindPreviousRun(job schemas.CrawlJob) (schemas.CrawlJob, error) {
+	var previous schemas.CrawlJob
+	err := repo.db.Connection.
+		Where("project_id = ? AND owner_id = ? AND seed_url = ? AND status = ? AND id < ?", job.ProjectId, job.OwnerId, job.SeedUrl, schemas.CrawlJobSucceeded, job.Id).
+		Order("id DESC").First(&previous)
+	if errors.Is(err.Error, gorm.ErrRecordNotFound) {
+		return previous, ErrNotFound
+	}
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return previous, nil
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type CrawlSitemapRepository interface {
+	SaveAll(urls []schemas.CrawlSitemapUrl)
+	FindByCrawlJob(crawlJobId uint64) []schemas.CrawlSitemapUrl
+}
+
+type crawlSitemapRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlSitemapRepository(conn *gorm.DB) CrawlSitemapRepository {
+	err := conn.AutoMigrate(&schemas.CrawlSitemapUrl{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlSitemapRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *crawlSitemapRepository) SaveAll(urls []schemas.CrawlSitemapUrl) {
+	if len(urls) == 0 {
+		return
+	}
+	err := repo.db.Connection.CreateInBatches(&urls, 500)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlSitemapRepository) FindByCrawlJob(crawlJobId uint64) []schemas.CrawlSitemapUrl {
+	var urls []schemas.CrawlSitemapUrl
+	err := repo.db.Connection.Where(&schemas.CrawlSitemapUrl{CrawlJobId: crawlJobId}).Order("id").Find(&urls)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return urls
+}
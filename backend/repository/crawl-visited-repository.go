@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type CrawlVisitedRepository interface {
+	SaveAll(urls []schemas.CrawlVisitedUrl)
+	Exists(crawlJobId uint64, hash int64, url string) bool
+	FindByCrawlJob(crawlJobId uint64, fn func(urls []schemas.CrawlVisitedUrl))
+	DeleteByCrawlJob(crawlJobId uint64)
+}
+
+type crawlVisitedRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlVisitedRepository(conn *gorm.DB) CrawlVisitedRepository {
+	err := conn.AutoMigrate(&schemas.CrawlVisitedUrl{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlVisitedRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *crawlVisitedRepository) SaveAll(urls []schemas.CrawlVisitedUrl) {
+	if len(urls) == 0 {
+		return
+	}
+	err := repo.db.Connection.CreateInBatches(&urls, 500)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlVisitedRepository) Exists(crawlJobId uint64, hash int64, url string) bool {
+	var count int64
+	err := repo.db.Connection.Model(&schemas.CrawlVisitedUrl{}).
+		Where("crawl_job_id = ? AND hash = ? AND url = ?", crawlJobId, hash, url).
+		Limit(1).
+		Count(&count)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return count > 0
+}
+
+// FindByCrawlJob calls fn with the URLs visited by a crawl, a batch at a time.
+func (repo *crawlVisitedRepository) FindByCrawlJob(crawlJobId uint64, fn func(urls []schemas.CrawlVisitedUrl)) {
+	var urls []schemas.CrawlVisitedUrl
+	err := repo.db.Connection.Where(&schemas.CrawlVisitedUrl{CrawlJobId: crawlJobId}).FindInBatches(&urls, 1000, func(tx *gorm.DB, batch int) error {
+		fn(urls)
+		return nil
+	})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlVisitedRepository) DeleteByCrawlJob(crawlJobId uint64) {
+	err := repo.db.Connection.Where(&schemas.CrawlVisitedUrl{CrawlJobId: crawlJobId}).Delete(&schemas.CrawlVisitedUrl{})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
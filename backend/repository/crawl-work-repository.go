@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type CrawlWorkRepository interface {
+	Push(items []schemas.CrawlWorkItem, maxItems int) int64
+	FindExisting(crawlJobId uint64, hashes []int64) map[int64]bool
+	Claim(workerId string, limit int, lease time.Duration) []schemas.CrawlWorkItem
+	Extend(workerId string, lease time.Duration)
+	Complete(id uint64)
+	Release(id uint64, attempts int)
+	ReleaseWorker(workerId string)
+	Remaining(crawlJobId uint64) int64
+	DeleteByCrawlJob(crawlJobId uint64)
+}
+
+type crawlWorkRepository struct {
+	db *schemas.Database
+}
+
+func NewCrawlWorkRepository(conn *gorm.DB) CrawlWorkRepository {
+	err := conn.AutoMigrate(&schemas.CrawlWorkItem{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &crawlWorkRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+// Push queues the items not queued yet, as long as their crawl has less
+// than maxItems, 0 meaning no limit. The limit is only approximate when
+// several workers push at once.
+func (repo *crawlWorkRepository) Push(items []schemas.CrawlWorkItem, maxItems int) int64 {
+	if len(items) == 0 {
+		return 0
+	}
+	if maxItems > 0 {
+		var count int64
+		err := repo.db.Connection.Model(&schemas.CrawlWorkItem{}).Where(&schemas.CrawlWorkItem{CrawlJobId: items[0].CrawlJobId}).Count(&count)
+		if err.Error != nil {
+			panic(err.Error)
+		}
+		if count >= int64(maxItems) {
+			return 0
+		}
+		items = items[:min(int64(len(items)), int64(maxItems)-count)]
+	}
+	err := repo.db.Connection.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&items, 500)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return err.RowsAffected
+}
+
+func (repo *crawlWorkRepository) FindExisting(crawlJobId uint64, hashes []int64) map[int64]bool {
+	existing := make(map[int64]bool)
+	for start := 0; start < len(hashes); start += 500 {
+		var found []int64
+		err := repo.db.Connection.Model(&schemas.CrawlWorkItem{}).
+			Where("crawl_job_id = ? AND hash IN ?", crawlJobId, hashes[start:min(start+500, len(hashes))]).
+			Pluck("hash", &found)
+		if err.Error != nil {
+			panic(err.Error)
+		}
+		for _, hash := range found {
+			existing[hash] = true
+		}
+	}
+	return existing
+}
+
+// Claim leases up to limit items of the running crawls to a worker, either
+// pending or whose lease expired with their worker. The items leased by
+// other workers are skipped rather than waited for.
+func (repo *crawlWorkRepository) Claim(workerId string, limit int, lease time.Duration) []schemas.CrawlWorkItem {
+	var items []schemas.CrawlWorkItem
+	err := repo.db.Connection.Raw(`
+		UPDATE crawl_work_items SET status = ?, worker_id = ?, lease_until = now() + make_interval(secs => ?)
+		WHERE id IN (
+			SELECT item.id FROM crawl_work_items item
+			JOIN crawl_jobs job ON job.id = item.crawl_job_id
+			WHERE job.status = ? AND (item.status = ? OR (item.status = ? AND item.lease_until < now()))
+			ORDER BY item.id
+			LIMIT ?
+			FOR UPDATE OF item SKIP LOCKED
+		)
+		RETURNING *`,
+		schemas.CrawlWorkLeased, workerId, lease.Seconds(),
+		schemas.CrawlJobRunning, schemas.CrawlWorkPending, schemas.CrawlWorkLeased,
+		limit,
+	).Scan(&items)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return items
+}
+
+// Extend renews the leases of the items being fetched by a worker, which
+// proves it is still alive.
+func (repo *crawlWorkRepository) Extend(workerId string, lease time.Duration) {
+	err := repo.db.Connection.Model(&schemas.CrawlWorkItem{}).
+		Where(&schemas.CrawlWorkItem{WorkerId: workerId, Status: schemas.CrawlWorkLeased}).
+		Update("lease_until", gorm.Expr("now() + make_interval(secs => ?)", lease.Seconds()))
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *crawlWorkRepository) Complete(id uint64) {
+	err := repo.db.Connection.Model(&schemas.CrawlWorkItem{Id: id}).Updates(map[string]interface{}{
+		"status":      schemas.CrawlWorkDone,
+		"worker_id":   "",
+		"lease_until": nil,
+	})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+// Release makes an item pending again, to be fetched by any worker.
+func (repo *crawlWorkRepository) Release(id uint64, attempts int) {
+	err := repo.db.Connection.Model(&schemas.CrawlWorkItem{Id: id}).Updates(map[string]interface{}{
+		"status":      schemas.CrawlWorkPending,
+		"attempts":    attempts,
+		"worker_id":   "",
+		"lease_until": nil,
+	})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+// ReleaseWorker makes the items leased by a worker which stops pending again.
+func (repo *crawlWorkRepository) ReleaseWorker(workerId string) {
+	err := repo.db.Connection.Model(&schemas.CrawlWorkItem{}).
+		Where(&schemas.CrawlWorkItem{WorkerId: workerId, Status: schemas.CrawlWorkLeased}).
+		Updates(map[string]interface{}{
+			"status":      schemas.CrawlWorkPending,
+			"worker_id":   "",
+			"lease_until": nil,
+		})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+// Remaining counts the items of a crawl which are not done.
+func (repo *crawlWorkRepository) Remaining(crawlJobId uint64) int64 {
+	var count int64
+	err := repo.db.Connection.Model(&schemas.CrawlWorkItem{}).
+		Where("crawl_job_id = ? AND status <> ?", crawlJobId, schemas.CrawlWorkDone).
+		Count(&count)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return count
+}
+
+func (repo *crawlWorkRepository) DeleteByCrawlJob(crawlJobId uint64) {
+	err := repo.db.Connection.Where(&schemas.CrawlWorkItem{CrawlJobId: crawlJobId}).Delete(&schemas.CrawlWorkItem{})
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
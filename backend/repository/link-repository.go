@@ -2,7 +2,9 @@ package repository
 
 import (
 	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/tools"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type LinkRepository interface {
@@ -10,6 +12,15 @@ type LinkRepository interface {
 	Update(link schemas.Link)
 	Delete(link schemas.Link)
 	FindAll() []schemas.Link
+	FindOrCreateUrl(url string) schemas.LinkUrl
+	FindBrokenByCrawlJob(crawlJobId uint64) []schemas.Link
+	FindSuspectByCrawlJob(crawlJobId uint64) []schemas.Link
+	FindByCrawlJob(crawlJobId uint64) []schemas.Link
+	FindByCrawlJobAndUrls(crawlJobId uint64, urls []string) []schemas.Link
+	FindRedirectedByCrawlJob(crawlJobId uint64) []schemas.Link
+	FindLastCrawled(crawlJobId uint64, url string) (schemas.Link, error)
+	EachByCrawlJob(crawlJobId uint64, brokenOnly bool, fn func(links []schemas.Link) error) error
+	CountByCrawlJob(crawlJobId uint64) (checked int64, broken int64)
 }
 
 type linkRepository struct {
@@ -17,7 +28,11 @@ type linkRepository struct {
 }
 
 func NewLinkRepository(conn *gorm.DB) LinkRepository {
-	err := conn.AutoMigrate(&schemas.LinkUrl{}, &schemas.Link{})
+	err := migrateOnce(conn, "normalize_link_urls", normalizeLinkUrls)
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	err = conn.AutoMigrate(&schemas.LinkUrl{}, &schemas.Link{})
 	if err != nil {
 		panic("failed to migrate database")
 	}
@@ -29,19 +44,31 @@ func NewLinkRepository(conn *gorm.DB) LinkRepository {
 }
 
 func (repo *linkRepository) Save(video schemas.Link) {
-	err := repo.db.Connection.Create(&video)
+	repo.resolveUrl(&video)
+	err := repo.db.Connection.Omit(clause.Associations).Create(&video)
 	if err.Error != nil {
 		panic(err.Error)
 	}
 }
 
 func (repo *linkRepository) Update(video schemas.Link) {
-	err := repo.db.Connection.Save(&video)
+	repo.resolveUrl(&video)
+	err := repo.db.Connection.Omit(clause.Associations).Save(&video)
 	if err.Error != nil {
 		panic(err.Error)
 	}
 }
 
+// resolveUrl points link to the row of its normalized URL when it comes
+// with one, rather than inserting the URL as given.
+func (repo *linkRepository) resolveUrl(link *schemas.Link) {
+	if link.UrlId.Url == "" {
+		return
+	}
+	link.UrlId = repo.FindOrCreateUrl(link.UrlId.Url)
+	link.LinkId = link.UrlId.Id
+}
+
 func (repo *linkRepository) Delete(video schemas.Link) {
 	err := repo.db.Connection.Delete(&video)
 	if err.Error != nil {
@@ -57,3 +84,198 @@ func (repo *linkRepository) FindAll() []schemas.Link {
 	}
 	return links
 }
+
+func (repo *linkRepository) FindOrCreateUrl(url string) schemas.LinkUrl {
+	url = tools.NormalizeUrl(url)
+	linkUrl := schemas.LinkUrl{Url: url}
+	// Concurrent crawls may insert the same URL, let the unique constraint decide.
+	err := repo.db.Connection.Clauses(clause.OnConflict{DoNothing: true}).Create(&linkUrl)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	if linkUrl.Id != 0 {
+		return linkUrl
+	}
+	err = repo.db.Connection.Where(&schemas.LinkUrl{Url: url}).First(&linkUrl)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return linkUrl
+}
+
+func (repo *linkRepository) FindBrokenByCrawlJob(crawlJobId uint64) []schemas.Link {
+	var links []schemas.Link
+	err := repo.db.Connection.Preload("UrlId").Where(&schemas.Link{CrawlJobId: crawlJobId, Broken: true}).Order("id").Find(&links)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return links
+}
+
+func (repo *linkRepository) FindSuspectByCrawlJob(crawlJobId uint64) []schemas.Link {
+	var links []schemas.Link
+	err := repo.db.Connection.Preload("UrlId").Where(&schemas.Link{CrawlJobId: crawlJobId, Suspect: true}).Order("id").Find(&links)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return links
+}
+
+func (repo *linkRepository) FindByCrawlJob(crawlJobId uint64) []schemas.Link {
+	var links []schemas.Link
+	err := repo.db.Connection.Preload("UrlId").Where(&schemas.Link{CrawlJobId: crawlJobId}).Order("id").Find(&links)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return links
+}
+
+func (repo *linkRepository) FindByCrawlJobAndUrls(crawlJobId uint64, urls []string) []schemas.Link {
+	var links []schemas.Link
+	for start := 0; start < len(urls); start += 500 {
+		var batch []schemas.Link
+		linkUrls := repo.db.Connection.Model(&schemas.LinkUrl{}).Select("id").Where("url IN ?", urls[start:min(start+500, len(urls))])
+		err := repo.db.Connection.Preload("UrlId").Where("crawl_job_id = ? AND link_id IN (?)", crawlJobId, linkUrls).Order("id").Find(&batch)
+		if err.Error != nil {
+			panic(err.Error)
+		}
+		links = append(links, batch...)
+	}
+	return links
+}
+
+func (repo *linkRepository) FindRedirectedByCrawlJob(crawlJobId uint64) []schemas.Link {
+	var links []schemas.Link
+	err := repo.db.Connection.Preload("UrlId").Where("crawl_job_id = ? AND redirect_count > 0", crawlJobId).Order("id").Find(&links)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return links
+}
+
+// FindLastCrawled returns the last copy of a page downloaded by a succeeded
+// crawl of the same site as a crawl job, provided it came with validators.
+func (repo *linkRepository) FindLastCrawled(crawlJobId uint64, url string) (schemas.Link, error) {
+	var links []schemas.Link
+	linkUrl := repo.db.Connection.Model(&schemas.LinkUrl{}).Select("id").Where(&schemas.LinkUrl{Url: tools.NormalizeUrl(url)})
+	err := repo.db.Connection.
+		Joins("JOIN crawl_jobs previous ON previous.id = links.crawl_job_id").
+		Joins("JOIN crawl_jobs job ON job.id = ? AND job.project_id = previous.project_id AND job.seed_url = previous.seed_url", crawlJobId).
+		Where("links.link_id = (?) AND previous.id <> job.id AND previous.status = ?", linkUrl, schemas.CrawlJobSucceeded).
+		Where("links.method = ? AND NOT links.broken AND (links.etag <> '' OR links.last_modified <> '')", "GET").
+		Order("links.id DESC").Limit(1).Find(&links)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	if len(links) == 0 {
+		return schemas.Link{}, ErrNotFound
+	}
+	return links[0], nil
+}
+
+// EachByCrawlJob calls fn with the links checked by a crawl, a batch at a
+// time, until it fails.
+func (repo *linkRepository) EachByCrawlJob(crawlJobId uint64, brokenOnly bool, fn func(links []schemas.Link) error) error {
+	var links []schemas.Link
+	var fnErr error
+	query := repo.db.Connection.Preload("UrlId").Where(&schemas.Link{CrawlJobId: crawlJobId})
+	if brokenOnly {
+		query = query.Where(&schemas.Link{Broken: true})
+	}
+	err := query.FindInBatches(&links, 1000, func(tx *gorm.DB, batch int) error {
+		fnErr = fn(links)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return nil
+}
+
+func (repo *linkRepository) CountByCrawlJob(crawlJobId uint64) (checked int64, broken int64) {
+	err := repo.db.Connection.Model(&schemas.Link{}).Where(&schemas.Link{CrawlJobId: crawlJobId}).Count(&checked)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	err = repo.db.Connection.Model(&schemas.Link{}).Where(&schemas.Link{CrawlJobId: crawlJobId, Broken: true}).Count(&broken)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return checked, broken
+}
+
+// normalizeLinkUrls normalizes the URLs stored before they were, the rows
+// of the URLs which then name the same resource being merged into the
+// oldest one before the unique constraint is checked again.
+func normalizeLinkUrls(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&schemas.LinkUrl{}) {
+		return nil
+	}
+	groups := make(map[string][]schemas.LinkUrl)
+	var batch []schemas.LinkUrl
+	err := tx.Order("id").FindInBatches(&batch, 1000, func(_ *gorm.DB, _ int) error {
+		for _, linkUrl := range batch {
+			normalized := tools.NormalizeUrl(linkUrl.Url)
+			groups[normalized] = append(groups[normalized], linkUrl)
+		}
+		return nil
+	})
+	if err.Error != nil {
+		return err.Error
+	}
+
+	for normalized, linkUrls := range groups {
+		kept := linkUrls[0]
+		if len(linkUrls) == 1 && kept.Url == normalized {
+			continue
+		}
+		ids := make([]uint64, 0, len(linkUrls))
+		for _, linkUrl := range linkUrls {
+			ids = append(ids, linkUrl.Id)
+		}
+		if err := mergeLinkUrls(tx, kept.Id, ids); err != nil {
+			return err
+		}
+		if err := tx.Model(&kept).Update("url", normalized).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLinkUrls points the rows referring to the URLs of ids to the one of
+// kept and deletes the others, only keeping the last checked state.
+func mergeLinkUrls(tx *gorm.DB, kept uint64, ids []uint64) error {
+	duplicates := ids[1:]
+	if len(duplicates) == 0 {
+		return nil
+	}
+	for _, model := range []interface{}{&schemas.Link{}, &schemas.LinkToLinkUrl{}} {
+		if !tx.Migrator().HasTable(model) {
+			continue
+		}
+		if err := tx.Model(model).Where("link_id IN ?", duplicates).Update("link_id", kept).Error; err != nil {
+			return err
+		}
+	}
+	if tx.Migrator().HasTable(&schemas.LinkState{}) {
+		var states []schemas.LinkState
+		if err := tx.Where("link_id IN ?", ids).Order("checked_at DESC").Find(&states).Error; err != nil {
+			return err
+		}
+		if len(states) > 0 {
+			for _, state := range states[1:] {
+				if err := tx.Delete(&state).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&states[0]).Update("link_id", kept).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Where("id IN ?", duplicates).Delete(&schemas.LinkUrl{}).Error
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type LinkStateRepository interface {
+	// Transition applies transition to the state of a URL, the states of
+	// the same URL being updated one at a time.
+	Transition(linkId uint64, transition func(state *schemas.LinkState)) schemas.LinkState
+	FindByState(state string) []schemas.LinkState
+	FindByLinks(linkIds []uint64) []schemas.LinkState
+}
+
+type linkStateRepository struct {
+	db *schemas.Database
+}
+
+func NewLinkStateRepository(conn *gorm.DB) LinkStateRepository {
+	err := conn.AutoMigrate(&schemas.LinkState{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &linkStateRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *linkStateRepository) Transition(linkId uint64, transition func(state *schemas.LinkState)) schemas.LinkState {
+	var state schemas.LinkState
+	err := repo.db.Connection.Transaction(func(tx *gorm.DB) error {
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&schemas.LinkState{LinkId: linkId, State: schemas.LinkStateOk})
+		if created.Error != nil {
+			return created.Error
+		}
+		found := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&schemas.LinkState{LinkId: linkId}).First(&state)
+		if found.Error != nil {
+			return found.Error
+		}
+		transition(&state)
+		return tx.Save(&state).Error
+	})
+	if err != nil {
+		panic(err)
+	}
+	return state
+}
+
+func (repo *linkStateRepository) FindByState(state string) []schemas.LinkState {
+	var states []schemas.LinkState
+	err := repo.db.Connection.Preload("UrlId").Where(&schemas.LinkState{State: state}).Order("changed_at desc").Find(&states)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return states
+}
+
+func (repo *linkStateRepository) FindByLinks(linkIds []uint64) []schemas.LinkState {
+	var states []schemas.LinkState
+	if len(linkIds) == 0 {
+		return states
+	}
+	err := repo.db.Connection.Where("link_id IN ?", linkIds).Find(&states)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return states
+}
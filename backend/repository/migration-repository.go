@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+// migrateOnce runs migrate in a transaction unless the migration of that
+// name was already applied. The instances starting together wait for the
+// one which recorded it first.
+func migrateOnce(conn *gorm.DB, name string, migrate func(tx *gorm.DB) error) error {
+	err := conn.AutoMigrate(&schemas.Migration{})
+	if err != nil {
+		return err
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		recorded := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&schemas.Migration{Name: name})
+		if recorded.Error != nil {
+			return recorded.Error
+		}
+		if recorded.RowsAffected == 0 {
+			return nil
+		}
+		return migrate(tx)
+	})
+}
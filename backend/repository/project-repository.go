@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type ProjectRepository interface {
+	Save(project schemas.Project) (projectId uint64)
+	Update(project schemas.Project)
+	Delete(project schemas.Project)
+	FindAll() []schemas.Project
+	FindByOwner(ownerId uint64) []schemas.Project
+	FindById(id uint64) (schemas.Project, error)
+}
+
+type projectRepository struct {
+	db *schemas.Database
+}
+
+func NewProjectRepository(conn *gorm.DB) ProjectRepository {
+	err := conn.AutoMigrate(&schemas.Project{})
+	if err != nil {
+		panic("failed to migrate database")
+	}
+	return &projectRepository{
+		db: &schemas.Database{
+			Connection: conn,
+		},
+	}
+}
+
+func (repo *projectRepository) Save(project schemas.Project) (projectId uint64) {
+	err := repo.db.Connection.Create(&project)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return project.Id
+}
+
+func (repo *projectRepository) Update(project schemas.Project) {
+	err := repo.db.Connection.Save(&project)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *projectRepository) Delete(project schemas.Project) {
+	err := repo.db.Connection.Delete(&project)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+}
+
+func (repo *projectRepository) FindAll() []schemas.Project {
+	var projects []schemas.Project
+	err := repo.db.Connection.Order("id").Find(&projects)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return projects
+}
+
+func (repo *projectRepository) FindByOwner(ownerId uint64) []schemas.Project {
+	var projects []schemas.Project
+	err := repo.db.Connection.Where("owner_id = ?", ownerId).Order("id").Find(&projects)
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return projects
+}
+
+func (repo *projectRepository) FindById(id uint64) (schemas.Project, error) {
+	var project schemas.Project
+	err := repo.db.Connection.Where("id = ?", id).First(&project)
+	if errors.Is(err.Error, gorm.ErrRecordNotFound) {
+		return project, ErrNotFound
+	}
+	if err.Error != nil {
+		panic(err.Error)
+	}
+	return project, nil
+}
@@ -0,0 +1,421 @@
+package schemas
+
+import "time"
+
+// Status of a crawl job.
+const (
+	CrawlJobQueued    = "queued"
+	CrawlJobRunning   = "running"
+	CrawlJobPaused    = "paused"
+	CrawlJobSucceeded = "succeeded"
+	CrawlJobFailed    = "failed"
+	CrawlJobCancelled = "cancelled"
+)
+
+// Kinds of the resources referenced by a page.
+const (
+	ResourceAnchor     = "anchor"
+	ResourceImage      = "image"
+	ResourceStylesheet = "stylesheet"
+	ResourceScript     = "script"
+	ResourceFrame      = "frame"
+	ResourceMedia      = "media"
+	ResourceLink       = "link" // <link> other than a stylesheet
+	ResourceRefresh    = "refresh"
+	ResourceCss        = "css" // url() of a stylesheet or a style attribute
+)
+
+// Policies for the links marked nofollow, by their rel or by the page.
+const (
+	NoFollowCheck  = "check"  // Checked but not crawled further
+	NoFollowFollow = "follow" // Handled like any other link
+	NoFollowIgnore = "ignore" // Neither checked nor recorded
+)
+
+// How the resources which are not crawled further are checked.
+const (
+	CheckMethodHead = "head" // HEAD, falling back to a ranged GET
+	CheckMethodGet  = "get"
+)
+
+// Policies for the links leaving the allowed hosts of a crawl.
+const (
+	ExternalCheck  = "check"  // Checked but not crawled further
+	ExternalFollow = "follow" // Crawled like the allowed hosts
+	ExternalIgnore = "ignore" // Neither checked nor recorded
+)
+
+// Handling of the query strings of the URLs found by a crawl.
+const (
+	QueryKeep  = "keep"
+	QueryStrip = "strip" // Removed from the URLs
+	QuerySkip  = "skip"  // The URLs with a query are not fetched
+)
+
+// Kinds of the crawler traps detected by a crawl.
+const (
+	TrapRepeatingPath     = "repeating_path"     // A path segment repeated over and over
+	TrapQueryCombinations = "query_combinations" // Ever more query parameter combinations on a path
+	TrapUrlPattern        = "url_pattern"        // Too many URLs only differing by their ids
+)
+
+// CrawlScope restricts the URLs a crawl fetches. The seeds are always fetched.
+type CrawlScope struct {
+	// Hosts crawled, *.example.com matching example.com and its subdomains.
+	// The host of the seed when empty.
+	AllowedHosts []string `json:"allowed_hosts"`
+	// Path prefixes of the URLs crawled, any when empty, and of the ones skipped
+	IncludePaths []string `json:"include_paths"`
+	ExcludePaths []string `json:"exclude_paths"`
+	// Regular expressions matched against the whole URLs
+	IncludePatterns []string `json:"include_patterns"`
+	ExcludePatterns []string `json:"exclude_patterns"`
+	External        string   `json:"external" binding:"omitempty,oneof=check follow ignore"`
+	Query           string   `json:"query" binding:"omitempty,oneof=keep strip skip"`
+}
+
+// CrawlSettings holds the limits and policies applied to a crawl.
+type CrawlSettings struct {
+	MaxDepth int `json:"max_depth" binding:"gte=0"`
+	MaxPages int `json:"max_pages" binding:"gte=0"`
+	Workers  int `json:"workers" binding:"gte=0,lte=64"`
+	// Deadlines in seconds
+	RequestTimeout int `json:"request_timeout" binding:"gte=0"`
+	Timeout        int `json:"timeout" binding:"gte=0"`
+	// Politeness towards every host, shared with the other crawls of the host
+	HostConcurrency       int     `json:"host_concurrency" binding:"gte=0,lte=16"`
+	HostRequestsPerSecond float64 `json:"host_requests_per_second" binding:"gte=0"`
+	// Only honored in the settings of a project, for the sites we own
+	IgnoreRobots bool `json:"ignore_robots"`
+	// Do not seed the crawl with the URLs of the sitemaps of the site
+	IgnoreSitemaps bool `json:"ignore_sitemaps"`
+	// Kinds of resources checked, all of them when empty
+	ResourceKinds []string `json:"resource_kinds" binding:"omitempty,dive,oneof=anchor image stylesheet script frame media link refresh css"`
+	NoFollow      string   `json:"nofollow" binding:"omitempty,oneof=check follow ignore"`
+	// Query parameters stripped from the URLs, a trailing * matching any
+	// suffix. The usual tracking parameters when empty, unless kept.
+	TrackingParams     []string `json:"tracking_params"`
+	KeepTrackingParams bool     `json:"keep_tracking_params"`
+	// Redirect chains longer than this are reported
+	RedirectChainLimit int `json:"redirect_chain_limit" binding:"gte=0"`
+	// Regular expressions marking the pages answered 200 as not found,
+	// matched against their title and their text
+	Soft404TitlePatterns []string `json:"soft404_title_patterns"`
+	Soft404BodyPatterns  []string `json:"soft404_body_patterns"`
+	DisableSoft404       bool     `json:"disable_soft404"`
+	CheckMethod          string   `json:"check_method" binding:"omitempty,oneof=head get"`
+	// Bytes downloaded of the pages parsed
+	MaxBodySize int64 `json:"max_body_size" binding:"gte=0"`
+	// Attempts of a fetch failing with a transient error, the delay between
+	// them in milliseconds doubling every time
+	RetryAttempts int `json:"retry_attempts" binding:"gte=0,lte=10"`
+	RetryBackoff  int `json:"retry_backoff" binding:"gte=0"`
+	// A URL is broken after failing this many consecutive checks over at
+	// least BrokenAfterSeconds, and recovered after succeeding this many
+	BrokenAfterFailures     int        `json:"broken_after_failures" binding:"gte=0"`
+	BrokenAfterSeconds      int        `json:"broken_after_seconds" binding:"gte=0"`
+	RecoveredAfterSuccesses int        `json:"recovered_after_successes" binding:"gte=0"`
+	Scope                   CrawlScope `json:"scope"`
+	// Thresholds past which the URLs of a pattern are not followed, see
+	// CrawlTrap, applied by each worker when the crawl is run by several
+	TrapSegmentRepeats    int  `json:"trap_segment_repeats" binding:"gte=0"`
+	TrapQueryCombinations int  `json:"trap_query_combinations" binding:"gte=0"`
+	TrapPatternUrls       int  `json:"trap_pattern_urls" binding:"gte=0"`
+	DisableTrapDetection  bool `json:"disable_trap_detection"`
+	// URLs of the frontier kept in memory, the others waiting on disk
+	FrontierMemory int `json:"frontier_memory" binding:"gte=0"`
+	// Downloads every page again instead of asking the server whether the
+	// ones of the last crawl of the site changed
+	FullRecrawl bool `json:"full_recrawl"`
+}
+
+// CrawlProgress holds the counters of a crawl job.
+type CrawlProgress struct {
+	PagesFetched uint64 `json:"pages_fetched"`
+	BrokenLinks  uint64 `json:"broken_links"`
+	// URLs which failed without being confirmed broken yet
+	SuspectLinks uint64 `json:"suspect_links"`
+	PagesSkipped uint64 `json:"pages_skipped"`
+	// Fetched pages answered 304, their links being the ones of the last crawl
+	PagesUnchanged uint64 `json:"pages_unchanged"`
+}
+
+// CrawlTrap is a pattern of URLs which the crawl stopped following, likely
+// an infinite calendar, session ids or faceted search pages.
+type CrawlTrap struct {
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern"` // Host and path, {n} and {id} standing for the ids
+	Example string `json:"example"` // First URL not followed
+	Skipped int    `json:"skipped"` // Links not followed
+}
+
+// CrawlJob represents the crawl_jobs entity in the database.
+type CrawlJob struct {
+	Id        uint64        `json:"id,omitempty" gorm:"primary_key;auto_increment"`
+	ProjectId uint64        `json:"project_id,omitempty" gorm:"index"`
+	SeedUrl   string        `json:"seed_url" gorm:"type:text"`
+	Settings  CrawlSettings `json:"settings" gorm:"serializer:json;type:text"`
+	Status    string        `json:"status" gorm:"type:varchar(20);index"`
+	// OwnerId is the user who queued the crawl, the only one besides the
+	// admins who may see and control it.
+	OwnerId uint64 `json:"owner_id" gorm:"index"`
+	CrawlProgress
+	Traps      []CrawlTrap `json:"traps" gorm:"serializer:json;type:text"`
+	Error      string      `json:"error,omitempty" gorm:"type:text"`
+	CreatedAt  time.Time   `json:"created_at" gorm:"default:CURRENT_TIMESTAMP"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Elapsed    float64     `json:"elapsed_seconds" gorm:"-"`
+}
+
+// CrawlRequest is the body expected to enqueue a new crawl. The settings
+// given override the ones of the project.
+type CrawlRequest struct {
+	Url       string `json:"url" binding:"required_without=ProjectId,omitempty,url"`
+	ProjectId uint64 `json:"project_id"`
+	CrawlSettings
+}
+
+// CrawlFrontierItem is a URL of the frontier of a paused crawl, either still
+// to fetch or already fetched.
+type CrawlFrontierItem struct {
+	Id         uint64 `json:"-" gorm:"primary_key;auto_increment"`
+	CrawlJobId uint64 `json:"crawl_job_id" gorm:"index"`
+	Url        string `json:"url" gorm:"type:text"`
+	Depth      int    `json:"depth"`
+	Leaf       bool   `json:"leaf"`
+	Fetched    bool   `json:"fetched"`
+}
+
+// Status of a URL of a crawl run by the workers.
+const (
+	CrawlWorkPending = "pending"
+	CrawlWorkLeased  = "leased" // Being fetched by a worker until its lease expires
+	CrawlWorkDone    = "done"
+)
+
+// CrawlWorkItem is a URL of a crawl run by the workers, which lease it while
+// fetching it. The done items stay until the crawl finishes so that a URL is
+// only queued once.
+type CrawlWorkItem struct {
+	Id         uint64     `json:"-" gorm:"primary_key;auto_increment"`
+	CrawlJobId uint64     `json:"crawl_job_id" gorm:"uniqueIndex:idx_crawl_work_item_url"`
+	Hash       int64      `json:"-" gorm:"uniqueIndex:idx_crawl_work_item_url"` // Of the URL
+	Url        string     `json:"url" gorm:"type:text"`
+	Depth      int        `json:"depth"`
+	Leaf       bool       `json:"leaf"`
+	Attempts   int        `json:"attempts"`
+	Status     string     `json:"status" gorm:"type:varchar(10);index"`
+	WorkerId   string     `json:"worker_id,omitempty" gorm:"type:varchar(100);index"`
+	LeaseUntil *time.Time `json:"lease_until,omitempty"`
+}
+
+// CrawlHostSlot is when the next request to a host may start, shared by
+// the crawl workers so that they respect its limits together.
+type CrawlHostSlot struct {
+	Host   string    `json:"host" gorm:"primary_key;type:varchar(255)"`
+	NextAt time.Time `json:"next_at" gorm:"index"`
+}
+
+// CrawlVisitedUrl is a URL already queued by a running crawl too large to
+// keep them all in memory, looked up by the hash of the URL.
+type CrawlVisitedUrl struct {
+	Id         uint64 `json:"-" gorm:"primary_key;auto_increment"`
+	CrawlJobId uint64 `json:"crawl_job_id" gorm:"index:idx_crawl_visited_url"`
+	Hash       int64  `json:"-" gorm:"index:idx_crawl_visited_url"`
+	Url        string `json:"url" gorm:"type:text"`
+}
+
+// CrawlEdge represents a link found on a page during a crawl.
+type CrawlEdge struct {
+	Id         uint64    `json:"-" gorm:"primary_key;auto_increment"`
+	CrawlJobId uint64    `json:"-" gorm:"index:idx_crawl_edge_target;index:idx_crawl_edge_source"`
+	SourceUrl  string    `json:"source_url" gorm:"type:text;index:idx_crawl_edge_source"`
+	TargetUrl  string    `json:"target_url" gorm:"type:text;index:idx_crawl_edge_target"`
+	AnchorText string    `json:"anchor_text" gorm:"type:text"`
+	Tag        string    `json:"tag" gorm:"type:varchar(20)"`
+	Attribute  string    `json:"attribute" gorm:"type:varchar(20)"`
+	Rel        string    `json:"rel" gorm:"type:varchar(100)"`
+	Kind       string    `json:"kind" gorm:"type:varchar(20)"`
+	NoFollow   bool      `json:"nofollow"`
+	Fragment   string    `json:"fragment,omitempty" gorm:"type:text"`
+	CreatedAt  time.Time `json:"-" gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// CrawlSitemapUrl is a URL listed by a sitemap of the site of a crawl.
+type CrawlSitemapUrl struct {
+	Id         uint64 `json:"-" gorm:"primary_key;auto_increment"`
+	CrawlJobId uint64 `json:"-" gorm:"index"`
+	Url        string `json:"url" gorm:"type:text"`
+	Sitemap    string `json:"sitemap" gorm:"type:text"`
+}
+
+// SitemapIssue is a URL of a sitemap which is broken or redirected.
+type SitemapIssue struct {
+	Url         string `json:"url"`
+	Sitemap     string `json:"sitemap"`
+	StatusCode  uint64 `json:"status_code"`
+	Response    string `json:"response"`
+	RedirectUrl string `json:"redirect_url,omitempty"`
+}
+
+// SitemapReport compares the sitemaps of a site with what a crawl found.
+type SitemapReport struct {
+	Sitemaps   []string       `json:"sitemaps"`
+	Urls       int            `json:"urls"`
+	Broken     []SitemapIssue `json:"broken"`
+	Redirected []SitemapIssue `json:"redirected"`
+	// Pages of the site found by crawling but listed by no sitemap
+	MissingFromSitemap []string `json:"missing_from_sitemap"`
+}
+
+// RedirectReport is a URL checked by a crawl which was redirected, with what
+// is wrong about its redirects.
+type RedirectReport struct {
+	Url        string        `json:"url"`
+	FinalUrl   string        `json:"final_url"`
+	StatusCode uint64        `json:"status_code"` // Of the final response
+	Chain      []RedirectHop `json:"chain"`
+	Loop       bool          `json:"loop"`
+	TooLong    bool          `json:"too_long"`
+	// A redirect leads from https to http
+	Downgrade bool `json:"downgrade"`
+	// Set when every redirect is permanent: the links should be updated
+	Suggestion string      `json:"suggestion,omitempty"`
+	Referrers  []CrawlEdge `json:"referrers"`
+}
+
+// Classes of the errors of a check.
+const (
+	ErrorClassDns              = "dns"
+	ErrorClassRefused          = "connection_refused"
+	ErrorClassTls              = "tls"
+	ErrorClassTimeout          = "timeout"
+	ErrorClassNetwork          = "network" // Any other failure to get a response
+	ErrorClassClient           = "http_4xx"
+	ErrorClassServer           = "http_5xx"
+	ErrorClassTooManyRedirects = "too_many_redirects"
+	ErrorClassRedirectLoop     = "redirect_loop"
+	ErrorClassSoft404          = "soft_404"
+	ErrorClassMissingAnchor    = "missing_anchor" // The page has no element named by the fragment
+)
+
+// Categories of broken links.
+const (
+	BrokenLinkHttpError     = "http error"
+	BrokenLinkFetchError    = "fetch error" // No response was received
+	BrokenLinkSoft404       = "soft 404"
+	BrokenLinkMissingAnchor = "missing anchor"
+)
+
+// BrokenLink is a broken URL reported with every page linking to it.
+type BrokenLink struct {
+	Url        string      `json:"url"`
+	Category   string      `json:"category"`
+	ErrorClass string      `json:"error_class,omitempty"`
+	Attempts   int         `json:"attempts,omitempty"`
+	State      string      `json:"state,omitempty"` // Of the URL across its checks
+	StatusCode uint64      `json:"status_code"`
+	Response   string      `json:"response"`
+	Referrers  []CrawlEdge `json:"referrers"`
+}
+
+// CrawlDiff is what changed between two crawls of a site, a URL being
+// reported in every list it belongs to.
+type CrawlDiff struct {
+	CrawlJobId uint64 `json:"crawl_job_id"`
+	AgainstId  uint64 `json:"against_id"` // Crawl compared with, the older one usually
+	// Broken URLs which were not broken or not checked by the other crawl
+	NewlyBroken []CrawlDiffUrl `json:"newly_broken"`
+	Fixed       []CrawlDiffUrl `json:"fixed"`
+	// URLs checked by only one of the crawls
+	NewPages      []CrawlDiffUrl `json:"new_pages"`
+	RemovedPages  []CrawlDiffUrl `json:"removed_pages"`
+	StatusChanges []CrawlDiffUrl `json:"status_changes"`
+	// Pages downloaded by both crawls whose content changed
+	ChangedPages []CrawlDiffUrl `json:"changed_pages"`
+}
+
+// CrawlDiffUrl is a URL as checked by the two crawls compared, the fields
+// of a crawl which did not check it being left empty.
+type CrawlDiffUrl struct {
+	Url                string `json:"url"`
+	StatusCode         uint64 `json:"status_code"`
+	Response           string `json:"response,omitempty"`
+	ErrorClass         string `json:"error_class,omitempty"`
+	PreviousStatusCode uint64 `json:"previous_status_code"`
+	PreviousResponse   string `json:"previous_response,omitempty"`
+	PreviousErrorClass string `json:"previous_error_class,omitempty"`
+	// Pages linking to a newly broken URL
+	Referrers []CrawlEdge `json:"referrers,omitempty"`
+}
+
+// Types of the events streamed to the clients following a crawl.
+const (
+	CrawlEventPage        = "page"
+	CrawlEventBrokenLink  = "broken_link"
+	CrawlEventSuspectLink = "suspect_link"
+	CrawlEventProgress    = "progress"
+	CrawlEventStatus      = "status"
+	CrawlEventFinished    = "finished"
+)
+
+// CrawlEvent is a page checked by a crawl, stored for the clients following
+// the crawl to catch up from the last event they received. The progress
+// and the status of the crawl are read from its job instead.
+type CrawlEvent struct {
+	Id         uint64 `json:"-" gorm:"primary_key;auto_increment"`
+	CrawlJobId uint64 `json:"crawl_job_id" gorm:"uniqueIndex:idx_crawl_event_sequence,priority:1"`
+	// Sequence numbers the events of a crawl in the order they are visible,
+	// unlike Id which concurrent transactions may commit out of order.
+	Sequence  uint64         `json:"id" gorm:"uniqueIndex:idx_crawl_event_sequence,priority:2"`
+	Type      string         `json:"type" gorm:"type:varchar(20)"`
+	Page      CrawlPageEvent `json:"page" gorm:"serializer:json;type:text"`
+	CreatedAt time.Time      `json:"created_at" gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// CrawlStreamTicket opens the event stream of a crawl for a short while,
+// passed as the ticket query parameter by the browsers.
+type CrawlStreamTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CrawlEventSequence is the last sequence number given to an event of a crawl.
+type CrawlEventSequence struct {
+	CrawlJobId uint64 `gorm:"primary_key;autoIncrement:false"`
+	Last       uint64
+}
+
+// CrawlPageEvent is the outcome of the check of a page, as streamed.
+type CrawlPageEvent struct {
+	Url        string `json:"url"`
+	Method     string `json:"method,omitempty"`
+	StatusCode int    `json:"status_code"`
+	Response   string `json:"response"`
+	ErrorClass string `json:"error_class,omitempty"`
+	Broken     bool   `json:"broken"`
+	Suspect    bool   `json:"suspect,omitempty"`
+	Skipped    bool   `json:"skipped,omitempty"` // By robots.txt
+	Unchanged  bool   `json:"unchanged,omitempty"`
+	Ping       uint64 `json:"ping"` // In milliseconds
+	Links      int    `json:"links"`
+}
+
+// CrawlResult is a URL checked by a crawl, as exported.
+type CrawlResult struct {
+	Url         string    `json:"url"`
+	StatusCode  uint64    `json:"status_code"`
+	Response    string    `json:"response"`
+	Broken      bool      `json:"broken"`
+	Suspect     bool      `json:"suspect,omitempty"` // Failed, but not confirmed broken yet
+	Skipped     bool      `json:"skipped,omitempty"` // By robots.txt
+	ErrorClass  string    `json:"error_class,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	Attempts    int       `json:"attempts"`
+	Ping        uint64    `json:"ping"` // In milliseconds
+	RedirectUrl string    `json:"redirect_url,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+	// Pages linking to the URL, only looked up when it is broken
+	Referrers []string `json:"referrers,omitempty"`
+}
@@ -1,7 +1,17 @@
 package schemas
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Database struct {
 	Connection *gorm.DB
 }
+
+// Migration records a one-off change of the data already applied.
+type Migration struct {
+	Name      string    `gorm:"primary_key;type:varchar(100)"`
+	AppliedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
@@ -2,25 +2,72 @@ package schemas
 
 import "time"
 
-// LinkUrl represents the URL entity in the database
+// LinkUrl represents the URL entity in the database, its URL being
+// normalized by the repository so that a resource is only stored once.
 type LinkUrl struct {
 	Id  uint64 `json:"id,omitempty" gorm:"primary_key;auto_increment"`
-	Url string `json:"url" binding:"required" gorm:"type:varchar(256);unique"`
+	Url string `json:"url" binding:"required" gorm:"type:text;unique"`
 }
 
 // Link represents the Link entity and is associated with LinkUrl
 type Link struct {
-	Id         uint64    `json:"id,omitempty" gorm:"primary_key;auto_increment"`
-	LinkId     uint64    `json:"-"` // Foreign key for LinkUrl
-	UrlId      LinkUrl   `json:"url_id,omitempty" gorm:"foreignKey:LinkId;references:Id"`
-	StatusCode uint64    `json:"status_code" binding:"required"`
-	Response   string    `json:"response" binding:"required" gorm:"type:varchar(100)"`
-	Ping       uint64    `json:"ping" binding:"required"`
-	CreatedAt  time.Time `json:"created_at" gorm:"default:CURRENT_TIMESTAMP"`
+	Id            uint64        `json:"id,omitempty" gorm:"primary_key;auto_increment"`
+	LinkId        uint64        `json:"-" gorm:"index"` // Foreign key for LinkUrl
+	UrlId         LinkUrl       `json:"url_id,omitempty" gorm:"foreignKey:LinkId;references:Id"`
+	StatusCode    uint64        `json:"status_code" binding:"required"`
+	Response      string        `json:"response" binding:"required" gorm:"type:varchar(100)"`
+	Ping          uint64        `json:"ping" binding:"required"`
+	Method        string        `json:"method,omitempty" gorm:"type:varchar(20)"`
+	Broken        bool          `json:"broken"`  // Confirmed broken across consecutive checks
+	Suspect       bool          `json:"suspect"` // Failed, but not confirmed broken yet
+	ErrorClass    string        `json:"error_class,omitempty" gorm:"type:varchar(30)"`
+	Attempts      int           `json:"attempts"`
+	RedirectUrl   string        `json:"redirect_url,omitempty" gorm:"type:text"` // Where the URL finally led
+	Redirects     []RedirectHop `json:"redirects,omitempty" gorm:"serializer:json;type:text"`
+	RedirectCount int           `json:"-" gorm:"index"`
+	Canonical     string        `json:"canonical,omitempty" gorm:"type:text"` // Canonical URL declared by the page
+	Anchors       []string      `json:"-" gorm:"serializer:json;type:text"`   // Ids and names of an HTML page, nil for other resources
+	// Validators of the page and SHA-256 of its body, sent back when it is
+	// crawled again to only download it if it changed
+	ETag         string    `json:"etag,omitempty" gorm:"column:etag;type:varchar(200)"`
+	LastModified string    `json:"last_modified,omitempty" gorm:"type:varchar(50)"`
+	ContentHash  string    `json:"content_hash,omitempty" gorm:"type:varchar(64)"`
+	Unchanged    bool      `json:"unchanged,omitempty"`                 // Answered 304 to a recrawl, StatusCode being the one of the copy kept
+	CrawlJobId   uint64    `json:"crawl_job_id,omitempty" gorm:"index"` // Set when the check was made by a crawl
+	CreatedAt    time.Time `json:"created_at" gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// States of a URL across its consecutive checks.
+const (
+	LinkStateOk        = "ok"
+	LinkStateSuspect   = "suspect" // Failing, but not for long enough to be broken
+	LinkStateBroken    = "broken"
+	LinkStateRecovered = "recovered" // Broken, then succeeded enough times
+)
+
+// LinkState is the state of a URL, confirmed across its consecutive checks
+// so that a one-off failure does not mark it broken.
+type LinkState struct {
+	Id                   uint64     `json:"-" gorm:"primary_key;auto_increment"`
+	LinkId               uint64     `json:"-" gorm:"uniqueIndex"` // Foreign key for LinkUrl
+	UrlId                LinkUrl    `json:"url_id,omitempty" gorm:"foreignKey:LinkId;references:Id"`
+	State                string     `json:"state" gorm:"type:varchar(20);index"`
+	ConsecutiveFailures  int        `json:"consecutive_failures"`
+	ConsecutiveSuccesses int        `json:"consecutive_successes"`
+	FailingSince         *time.Time `json:"failing_since,omitempty"`
+	CheckedAt            time.Time  `json:"checked_at"`
+	ChangedAt            time.Time  `json:"changed_at"`
+}
+
+// RedirectHop is a request of a redirect chain answered by a redirect.
+type RedirectHop struct {
+	Url        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Latency    uint64 `json:"latency"` // In milliseconds
 }
 
 type LinkToLinkUrl struct {
-	LinkId uint64 `json:"link_id" binding:"required"`
-	ActualLink  string `json:"actual_link" binding:"required"`
-	UrlId  LinkUrl `json:"url_id,omitempty" gorm:"foreignKey:LinkId;references:Id"`
+	LinkId     uint64  `json:"link_id" binding:"required"`
+	ActualLink string  `json:"actual_link" binding:"required"`
+	UrlId      LinkUrl `json:"url_id,omitempty" gorm:"foreignKey:LinkId;references:Id"`
 }
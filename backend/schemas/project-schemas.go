@@ -0,0 +1,19 @@
+package schemas
+
+import "time"
+
+// Project is a site monitored by SentryLink along with the settings of its crawls.
+type Project struct {
+	Id       uint64        `json:"id,omitempty" gorm:"primary_key;auto_increment"`
+	Name     string        `json:"name" binding:"required" gorm:"type:varchar(100)"`
+	Url      string        `json:"url" binding:"required,url" gorm:"type:text"`
+	Settings CrawlSettings `json:"settings" gorm:"serializer:json;type:text"`
+	// OwnerId is the user who created the project, the only one besides the
+	// admins who may see and change it.
+	OwnerId uint64 `json:"owner_id" gorm:"index"`
+	// Verified projects are sites known to belong to their owner, which alone
+	// may ignore robots.txt. Only the admins verify projects, theirs being
+	// verified when created.
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"created_at" gorm:"default:CURRENT_TIMESTAMP"`
+}
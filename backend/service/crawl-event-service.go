@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+const (
+	// crawlEventPoll is how often the events and the job of a followed
+	// crawl are looked up, the crawl being possibly run by other processes.
+	crawlEventPoll  = time.Second
+	crawlEventBatch = 500
+	// crawlEventKeepAlive is the longest silence of a stream, the progress
+	// being sent again to keep the proxies from closing it.
+	crawlEventKeepAlive = 15 * time.Second
+	// crawlEventGrace is how long the events of a finished crawl are kept
+	// for its clients to catch up, pruned every crawlEventPrune.
+	crawlEventGrace = time.Hour
+	crawlEventPrune = 10 * time.Minute
+)
+
+// CrawlStreamEvent is an event sent to a client following a crawl. Only the
+// stored events have an Id, their sequence number, the others telling the
+// current state of the crawl, which a client catching up receives anyway.
+type CrawlStreamEvent struct {
+	Id   uint64
+	Type string
+	// A schemas.CrawlPageEvent, the schemas.CrawlProgress or, for the
+	// status changes, the schemas.CrawlJob
+	Data interface{}
+}
+
+type CrawlEventService interface {
+	// Stream sends the events of a crawl following the one numbered
+	// lastEventId until the crawl is finished, ctx is done or send fails.
+	Stream(ctx context.Context, jobId uint64, lastEventId uint64, send func(event CrawlStreamEvent) error) error
+}
+
+type crawlEventService struct {
+	repository      repository.CrawlEventRepository
+	crawlJobService CrawlJobService
+}
+
+// NewCrawlEventService also starts pruning the events of the finished crawls.
+func NewCrawlEventService(crawlEventRepository repository.CrawlEventRepository, crawlJobService CrawlJobService) CrawlEventService {
+	service := &crawlEventService{
+		repository:      crawlEventRepository,
+		crawlJobService: crawlJobService,
+	}
+	go service.prune()
+	return service
+}
+
+func (service *crawlEventService) prune() {
+	for range time.Tick(crawlEventPrune) {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("failed to prune the crawl events:", r)
+				}
+			}()
+			service.repository.DeleteFinished(crawlEventGrace)
+		}()
+	}
+}
+
+func (service *crawlEventService) Stream(ctx context.Context, jobId uint64, lastEventId uint64, send func(event CrawlStreamEvent) error) error {
+	job, err := service.crawlJobService.findJob(jobId)
+	if err != nil {
+		return err
+	}
+	poll := time.NewTicker(crawlEventPoll)
+	defer poll.Stop()
+
+	status, progress := "", schemas.CrawlProgress{}
+	var lastSent time.Time
+	for first := true; ; first = false {
+		if !first {
+			job, err = service.crawlJobService.findJob(jobId)
+			if err != nil {
+				return err
+			}
+		}
+		// The job is read first so that no page checked before it finished
+		// is left out.
+		for {
+			events := service.repository.FindAfter(jobId, lastEventId, crawlEventBatch)
+			for _, event := range events {
+				if err := send(CrawlStreamEvent{Id: event.Sequence, Type: event.Type, Data: event.Page}); err != nil {
+					return err
+				}
+				lastEventId = event.Sequence
+				lastSent = time.Now()
+			}
+			if len(events) < crawlEventBatch {
+				break
+			}
+		}
+
+		if first || job.CrawlProgress != progress || time.Since(lastSent) >= crawlEventKeepAlive {
+			progress = job.CrawlProgress
+			if err := send(CrawlStreamEvent{Type: schemas.CrawlEventProgress, Data: progress}); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+		if crawlFinished(job) {
+			return send(CrawlStreamEvent{Type: schemas.CrawlEventFinished, Data: job})
+		}
+		if job.Status != status {
+			status = job.Status
+			if err := send(CrawlStreamEvent{Type: schemas.CrawlEventStatus, Data: job}); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-poll.C:
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package service
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+// Formats of the exports of a crawl.
+const (
+	ExportCsv       = "csv"
+	ExportJsonLines = "jsonl"
+	ExportJUnit     = "junit"
+	ExportSarif     = "sarif"
+)
+
+var ErrExportFormat = errors.New("unknown export format, expected csv, jsonl, junit or sarif")
+
+// sarifMaxLocations bounds the pages reported linking to a broken URL, a
+// link of the navigation of a site being on every page.
+const sarifMaxLocations = 10
+
+// crawlExporter writes the results of a crawl in a format, as they are read.
+type crawlExporter interface {
+	// Begin is given the number of results exported and how many of them
+	// are broken.
+	Begin(job schemas.CrawlJob, results int64, broken int64) error
+	Export(result schemas.CrawlResult) error
+	End() error
+}
+
+func newCrawlExporter(format string, w io.Writer) (crawlExporter, error) {
+	switch format {
+	case ExportCsv:
+		return &csvExporter{writer: csv.NewWriter(w)}, nil
+	case ExportJsonLines:
+		return &jsonLinesExporter{encoder: json.NewEncoder(w)}, nil
+	case ExportJUnit:
+		return &junitExporter{w: w, encoder: xml.NewEncoder(w)}, nil
+	case ExportSarif:
+		return &sarifExporter{w: w}, nil
+	}
+	return nil, ErrExportFormat
+}
+
+// Export writes the URLs checked by a crawl to w in format, the broken ones
+// only when brokenOnly. They are read and written a batch at a time.
+func (service *crawlJobService) Export(user RequestUser, id uint64, format string, brokenOnly bool, w io.Writer) error {
+	job, err := service.GetJob(user, id)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(w)
+	exporter, err := newCrawlExporter(format, buffered)
+	if err != nil {
+		return err
+	}
+
+	checked, broken := service.linkRepository.CountByCrawlJob(id)
+	if brokenOnly {
+		checked = broken
+	}
+	// The links to missing anchors are failures of their own, after the URLs.
+	missing := service.missingAnchors(id)
+	checked += int64(len(missing))
+	broken += int64(len(missing))
+	if err := exporter.Begin(job, checked, broken); err != nil {
+		return err
+	}
+	err = service.linkRepository.EachByCrawlJob(id, brokenOnly, func(links []schemas.Link) error {
+		referrers := service.referrers(id, links)
+		for _, link := range links {
+			result := schemas.CrawlResult{
+				Url:         link.UrlId.Url,
+				StatusCode:  link.StatusCode,
+				Response:    link.Response,
+				Broken:      link.Broken,
+				Suspect:     link.Suspect,
+				Skipped:     skippedLink(link),
+				ErrorClass:  link.ErrorClass,
+				Method:      link.Method,
+				Attempts:    link.Attempts,
+				Ping:        link.Ping,
+				RedirectUrl: link.RedirectUrl,
+				CheckedAt:   link.CreatedAt,
+				Referrers:   referrers[link.UrlId.Url],
+			}
+			if err := exporter.Export(result); err != nil {
+				return err
+			}
+		}
+		return buffered.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	for _, link := range missing {
+		if err := exporter.Export(missingAnchorResult(link)); err != nil {
+			return err
+		}
+	}
+	if err := exporter.End(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// referrers returns the pages linking to each broken link, once each.
+func (service *crawlJobService) referrers(id uint64, links []schemas.Link) map[string][]string {
+	urls := []string{}
+	for _, link := range links {
+		if link.Broken {
+			urls = append(urls, link.UrlId.Url)
+		}
+	}
+	referrers := make(map[string][]string, len(urls))
+	seen := make(map[[2]string]bool)
+	for _, edge := range service.edgeRepository.FindByTargets(id, urls) {
+		key := [2]string{edge.SourceUrl, edge.TargetUrl}
+		if !seen[key] {
+			seen[key] = true
+			referrers[edge.TargetUrl] = append(referrers[edge.TargetUrl], edge.SourceUrl)
+		}
+	}
+	return referrers
+}
+
+// missingAnchorResult reports a link to a missing anchor as a broken URL,
+// checked when its last referrer was found.
+func missingAnchorResult(link schemas.BrokenLink) schemas.CrawlResult {
+	result := schemas.CrawlResult{
+		Url:        link.Url,
+		StatusCode: link.StatusCode,
+		Response:   link.Response,
+		Broken:     true,
+		ErrorClass: schemas.ErrorClassMissingAnchor,
+		Referrers:  []string{},
+	}
+	seen := make(map[string]bool)
+	for _, edge := range link.Referrers {
+		if edge.CreatedAt.After(result.CheckedAt) {
+			result.CheckedAt = edge.CreatedAt
+		}
+		if !seen[edge.SourceUrl] {
+			seen[edge.SourceUrl] = true
+			result.Referrers = append(result.Referrers, edge.SourceUrl)
+		}
+	}
+	return result
+}
+
+type csvExporter struct {
+	writer *csv.Writer
+}
+
+func (exporter *csvExporter) Begin(job schemas.CrawlJob, results int64, broken int64) error {
+	return exporter.writer.Write([]string{
+		"url", "status_code", "response", "broken", "suspect", "skipped", "error_class", "method",
+		"attempts", "ping", "redirect_url", "checked_at", "referrers",
+	})
+}
+
+func (exporter *csvExporter) Export(result schemas.CrawlResult) error {
+	exporter.writer.Write([]string{
+		result.Url,
+		strconv.FormatUint(result.StatusCode, 10),
+		result.Response,
+		strconv.FormatBool(result.Broken),
+		strconv.FormatBool(result.Suspect),
+		strconv.FormatBool(result.Skipped),
+		result.ErrorClass,
+		result.Method,
+		strconv.Itoa(result.Attempts),
+		strconv.FormatUint(result.Ping, 10),
+		result.RedirectUrl,
+		result.CheckedAt.Format(time.RFC3339),
+		strings.Join(result.Referrers, " "),
+	})
+	exporter.writer.Flush()
+	return exporter.writer.Error()
+}
+
+func (exporter *csvExporter) End() error {
+	return nil
+}
+
+type jsonLinesExporter struct {
+	encoder *json.Encoder
+}
+
+func (exporter *jsonLinesExporter) Begin(job schemas.CrawlJob, results int64, broken int64) error {
+	return nil
+}
+
+func (exporter *jsonLinesExporter) Export(result schemas.CrawlResult) error {
+	return exporter.encoder.Encode(result)
+}
+
+func (exporter *jsonLinesExporter) End() error {
+	return nil
+}
+
+// junitExporter reports every URL checked as a test case, failed when it is
+// broken, in a single test suite named after the seed of the crawl.
+type junitExporter struct {
+	w       io.Writer
+	encoder *xml.Encoder
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+func (exporter *junitExporter) Begin(job schemas.CrawlJob, results int64, broken int64) error {
+	if _, err := io.WriteString(exporter.w, xml.Header); err != nil {
+		return err
+	}
+	exporter.encoder.Indent("", "  ")
+	tests := strconv.FormatInt(results, 10)
+	failures := strconv.FormatInt(broken, 10)
+	suites := xml.StartElement{Name: xml.Name{Local: "testsuites"}, Attr: []xml.Attr{
+		{Name: xml.Name{Local: "name"}, Value: "SentryLink"},
+		{Name: xml.Name{Local: "tests"}, Value: tests},
+		{Name: xml.Name{Local: "failures"}, Value: failures},
+	}}
+	suite := xml.StartElement{Name: xml.Name{Local: "testsuite"}, Attr: []xml.Attr{
+		{Name: xml.Name{Local: "name"}, Value: job.SeedUrl},
+		{Name: xml.Name{Local: "tests"}, Value: tests},
+		{Name: xml.Name{Local: "failures"}, Value: failures},
+		{Name: xml.Name{Local: "errors"}, Value: "0"},
+	}}
+	if job.StartedAt != nil {
+		suite.Attr = append(suite.Attr,
+			xml.Attr{Name: xml.Name{Local: "timestamp"}, Value: job.StartedAt.Format(time.RFC3339)},
+			xml.Attr{Name: xml.Name{Local: "time"}, Value: strconv.FormatFloat(job.Elapsed, 'f', 3, 64)},
+		)
+	}
+	if err := exporter.encoder.EncodeToken(suites); err != nil {
+		return err
+	}
+	return exporter.encoder.EncodeToken(suite)
+}
+
+func (exporter *junitExporter) Export(result schemas.CrawlResult) error {
+	testCase := junitTestCase{
+		Name:      result.Url,
+		Classname: result.Url,
+		Time:      strconv.FormatFloat(float64(result.Ping)/1000, 'f', 3, 64),
+	}
+	if target, err := url.Parse(result.Url); err == nil && target.Host != "" {
+		testCase.Classname = target.Host
+	}
+	switch {
+	case result.Broken:
+		text := result.Response
+		if len(result.Referrers) > 0 {
+			text += "\nlinked from:\n" + strings.Join(result.Referrers, "\n")
+		}
+		testCase.Failure = &junitFailure{
+			Message: result.Response,
+			Type:    result.ErrorClass,
+			Text:    text,
+		}
+	case result.Skipped:
+		testCase.Skipped = &junitSkipped{Message: result.Response}
+	}
+	return exporter.encoder.EncodeElement(testCase, xml.StartElement{Name: xml.Name{Local: "testcase"}})
+}
+
+func (exporter *junitExporter) End() error {
+	if err := exporter.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "testsuite"}}); err != nil {
+		return err
+	}
+	if err := exporter.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "testsuites"}}); err != nil {
+		return err
+	}
+	return exporter.encoder.Flush()
+}
+
+// sarifExporter reports the broken URLs as the results of a SARIF 2.1.0
+// log, one rule per class of error, located on the pages linking to them.
+// The log is written around the results, which are not held in memory.
+type sarifExporter struct {
+	w       io.Writer
+	results int
+}
+
+type sarifRule struct {
+	Id                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleId              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			Uri string `json:"uri"`
+		} `json:"artifactLocation"`
+	} `json:"physicalLocation"`
+}
+
+// sarifRules describes the classes of errors of the broken links.
+var sarifRules = []sarifRule{
+	{Id: schemas.ErrorClassClient, Name: "ClientError", ShortDescription: sarifMessage{Text: "The link is answered with a 4xx status"}},
+	{Id: schemas.ErrorClassServer, Name: "ServerError", ShortDescription: sarifMessage{Text: "The link is answered with a 5xx status"}},
+	{Id: schemas.ErrorClassSoft404, Name: "Soft404", ShortDescription: sarifMessage{Text: "The link leads to a not found page answered 200"}},
+	{Id: schemas.ErrorClassDns, Name: "DnsError", ShortDescription: sarifMessage{Text: "The host of the link does not resolve"}},
+	{Id: schemas.ErrorClassRefused, Name: "ConnectionRefused", ShortDescription: sarifMessage{Text: "The host of the link refuses connections"}},
+	{Id: schemas.ErrorClassTls, Name: "TlsError", ShortDescription: sarifMessage{Text: "The certificate of the host of the link is invalid"}},
+	{Id: schemas.ErrorClassTimeout, Name: "Timeout", ShortDescription: sarifMessage{Text: "The link did not answer in time"}},
+	{Id: schemas.ErrorClassNetwork, Name: "NetworkError", ShortDescription: sarifMessage{Text: "The link could not be fetched"}},
+	{Id: schemas.ErrorClassTooManyRedirects, Name: "TooManyRedirects", ShortDescription: sarifMessage{Text: "The link redirects too many times"}},
+	{Id: schemas.ErrorClassRedirectLoop, Name: "RedirectLoop", ShortDescription: sarifMessage{Text: "The redirects of the link loop"}},
+	{Id: schemas.ErrorClassMissingAnchor, Name: "MissingAnchor", ShortDescription: sarifMessage{Text: "The page linked to has no element named by the fragment of the link"}},
+}
+
+func sarifLevel(errorClass string) string {
+	if errorClass == schemas.ErrorClassSoft404 {
+		return "warning"
+	}
+	return "error"
+}
+
+func (exporter *sarifExporter) Begin(job schemas.CrawlJob, results int64, broken int64) error {
+	rules := make([]sarifRule, 0, len(sarifRules))
+	for _, rule := range sarifRules {
+		rule.DefaultConfiguration.Level = sarifLevel(rule.Id)
+		rules = append(rules, rule)
+	}
+	driver, err := json.Marshal(map[string]interface{}{
+		"name":    "SentryLink",
+		"version": "1.0",
+		"rules":   rules,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(exporter.w,
+		`{"$schema":"https://json.schemastore.org/sarif-2.1.0.json","version":"2.1.0","runs":[{"tool":{"driver":%s},"automationDetails":{"id":"sentrylink/crawl/%d/"},"results":[`,
+		driver, job.Id)
+	return err
+}
+
+func (exporter *sarifExporter) Export(result schemas.CrawlResult) error {
+	if !result.Broken {
+		return nil
+	}
+	ruleId := result.ErrorClass
+	if ruleId == "" {
+		ruleId = schemas.ErrorClassNetwork
+	}
+	message := fmt.Sprintf("Broken link to %s: %s", result.Url, result.Response)
+	if len(result.Referrers) > sarifMaxLocations {
+		message += fmt.Sprintf(" (linked from %d pages)", len(result.Referrers))
+	}
+	// The page itself when it is the seed, which no page links to
+	pages := result.Referrers
+	if len(pages) == 0 {
+		pages = []string{result.Url}
+	}
+	locations := make([]sarifLocation, 0, min(len(pages), sarifMaxLocations))
+	for _, page := range pages[:min(len(pages), sarifMaxLocations)] {
+		var location sarifLocation
+		location.PhysicalLocation.ArtifactLocation.Uri = page
+		locations = append(locations, location)
+	}
+	data, err := json.Marshal(sarifResult{
+		RuleId:              ruleId,
+		Level:               sarifLevel(ruleId),
+		Message:             sarifMessage{Text: message},
+		Locations:           locations,
+		PartialFingerprints: map[string]string{"brokenUrl/v1": result.Url},
+	})
+	if err != nil {
+		return err
+	}
+	if exporter.results > 0 {
+		if _, err := io.WriteString(exporter.w, ","); err != nil {
+			return err
+		}
+	}
+	exporter.results++
+	_, err = exporter.w.Write(data)
+	return err
+}
+
+func (exporter *sarifExporter) End() error {
+	_, err := io.WriteString(exporter.w, "]}]}\n")
+	return err
+}
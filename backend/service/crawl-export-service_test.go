@@ -0,0 +1,272 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+var (
+	exportCheckedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	exportJob       = schemas.CrawlJob{Id: 7, SeedUrl: "https://example.com/"}
+	exportResults   = []schemas.CrawlResult{
+		{Url: "https://example.com/", StatusCode: 200, Response: "OK", Method: "GET", Attempts: 1, Ping: 120, CheckedAt: exportCheckedAt},
+		{
+			Url: "https://example.com/gone", StatusCode: 404, Response: "Not Found", Broken: true,
+			ErrorClass: schemas.ErrorClassClient, Method: "HEAD", Attempts: 1, Ping: 30, CheckedAt: exportCheckedAt,
+			Referrers: []string{"https://example.com/", "https://example.com/about"},
+		},
+		{
+			Url: "https://example.com/empty", StatusCode: 200, Response: "soft 404", Broken: true,
+			ErrorClass: schemas.ErrorClassSoft404, Method: "GET", Attempts: 1, CheckedAt: exportCheckedAt,
+		},
+		{Url: "https://example.com/private", Response: "skipped (robots)", Skipped: true, CheckedAt: exportCheckedAt},
+		missingAnchorResult(schemas.BrokenLink{
+			Url:      "https://example.com/doc#intro",
+			Category: schemas.BrokenLinkMissingAnchor,
+			Response: `no element with the id or name "intro"`,
+			Referrers: []schemas.CrawlEdge{
+				{SourceUrl: "https://example.com/", CreatedAt: exportCheckedAt.Add(-time.Minute)},
+				{SourceUrl: "https://example.com/", CreatedAt: exportCheckedAt},
+			},
+		}),
+	}
+)
+
+// export writes exportResults in format.
+func export(t *testing.T, format string) []byte {
+	t.Helper()
+	var output bytes.Buffer
+	exporter, err := newCrawlExporter(format, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := int64(0)
+	for _, result := range exportResults {
+		if result.Broken {
+			broken++
+		}
+	}
+	if err := exporter.Begin(exportJob, int64(len(exportResults)), broken); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range exportResults {
+		if err := exporter.Export(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.End(); err != nil {
+		t.Fatal(err)
+	}
+	return output.Bytes()
+}
+
+func TestNewCrawlExporterUnknownFormat(t *testing.T) {
+	if _, err := newCrawlExporter("xlsx", &bytes.Buffer{}); !errors.Is(err, ErrExportFormat) {
+		t.Errorf("newCrawlExporter(xlsx) error = %v, want %v", err, ErrExportFormat)
+	}
+}
+
+func TestMissingAnchorResult(t *testing.T) {
+	result := exportResults[len(exportResults)-1]
+	if !result.Broken || result.ErrorClass != schemas.ErrorClassMissingAnchor {
+		t.Errorf("result is broken %v with class %q, want a broken %q", result.Broken, result.ErrorClass, schemas.ErrorClassMissingAnchor)
+	}
+	if !slices.Equal(result.Referrers, []string{"https://example.com/"}) {
+		t.Errorf("referrers = %q, want each page once", result.Referrers)
+	}
+	if !result.CheckedAt.Equal(exportCheckedAt) {
+		t.Errorf("checked at %v, want when the last referrer was found, %v", result.CheckedAt, exportCheckedAt)
+	}
+}
+
+func TestCsvExporter(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(export(t, ExportCsv))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(exportResults)+1 {
+		t.Fatalf("%d records, want a header and %d rows", len(records), len(exportResults))
+	}
+	header := records[0]
+	column := func(name string) int {
+		i := slices.Index(header, name)
+		if i < 0 {
+			t.Fatalf("no %s column in %q", name, header)
+		}
+		return i
+	}
+	tests := []struct {
+		row    int
+		column string
+		want   string
+	}{
+		{1, "url", "https://example.com/"},
+		{1, "broken", "false"},
+		{1, "checked_at", "2024-01-02T03:04:05Z"},
+		{2, "status_code", "404"},
+		{2, "broken", "true"},
+		{2, "error_class", schemas.ErrorClassClient},
+		{2, "referrers", "https://example.com/ https://example.com/about"},
+		{4, "skipped", "true"},
+		{5, "url", "https://example.com/doc#intro"},
+		{5, "error_class", schemas.ErrorClassMissingAnchor},
+	}
+	for _, test := range tests {
+		if got := records[test.row][column(test.column)]; got != test.want {
+			t.Errorf("row %d %s = %q, want %q", test.row, test.column, got, test.want)
+		}
+	}
+}
+
+func TestJsonLinesExporter(t *testing.T) {
+	lines := strings.Split(strings.TrimSuffix(string(export(t, ExportJsonLines)), "\n"), "\n")
+	if len(lines) != len(exportResults) {
+		t.Fatalf("%d lines, want %d", len(lines), len(exportResults))
+	}
+	for i, line := range lines {
+		var result schemas.CrawlResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		want := exportResults[i]
+		if result.Url != want.Url || result.Broken != want.Broken || result.Skipped != want.Skipped ||
+			result.ErrorClass != want.ErrorClass || !slices.Equal(result.Referrers, want.Referrers) {
+			t.Errorf("line %d = %+v, want %+v", i, result, want)
+		}
+	}
+}
+
+func TestJUnitExporter(t *testing.T) {
+	var report struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Suite    struct {
+			Name      string `xml:"name,attr"`
+			Tests     int    `xml:"tests,attr"`
+			Failures  int    `xml:"failures,attr"`
+			TestCases []struct {
+				Name      string `xml:"name,attr"`
+				Classname string `xml:"classname,attr"`
+				Failure   *struct {
+					Type string `xml:"type,attr"`
+					Text string `xml:",chardata"`
+				} `xml:"failure"`
+				Skipped *struct{} `xml:"skipped"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(export(t, ExportJUnit), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Tests != 5 || report.Failures != 3 || report.Suite.Tests != 5 || report.Suite.Failures != 3 {
+		t.Errorf("tests %d/%d, failures %d/%d, want 5 tests with 3 failures",
+			report.Tests, report.Suite.Tests, report.Failures, report.Suite.Failures)
+	}
+	if report.Suite.Name != exportJob.SeedUrl {
+		t.Errorf("suite named %q, want %q", report.Suite.Name, exportJob.SeedUrl)
+	}
+	cases := report.Suite.TestCases
+	if len(cases) != len(exportResults) {
+		t.Fatalf("%d test cases, want %d", len(cases), len(exportResults))
+	}
+	if cases[0].Failure != nil || cases[0].Skipped != nil || cases[0].Classname != "example.com" {
+		t.Errorf("first test case = %+v, want a passed test of example.com", cases[0])
+	}
+	if failure := cases[1].Failure; failure == nil || failure.Type != schemas.ErrorClassClient || !strings.Contains(failure.Text, "https://example.com/about") {
+		t.Errorf("second test case failure = %+v, want a %s linked from https://example.com/about", failure, schemas.ErrorClassClient)
+	}
+	if cases[3].Skipped == nil || cases[3].Failure != nil {
+		t.Errorf("fourth test case = %+v, want it skipped", cases[3])
+	}
+	if failure := cases[4].Failure; failure == nil || failure.Type != schemas.ErrorClassMissingAnchor {
+		t.Errorf("missing anchor failure = %+v, want a %s", failure, schemas.ErrorClassMissingAnchor)
+	}
+}
+
+func TestSarifExporter(t *testing.T) {
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []sarifRule `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			AutomationDetails struct {
+				Id string `json:"id"`
+			} `json:"automationDetails"`
+			Results []sarifResult `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(export(t, ExportSarif), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("version %q with %d runs, want 2.1.0 with one run", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	if run.AutomationDetails.Id != "sentrylink/crawl/7/" {
+		t.Errorf("automation id = %q, want sentrylink/crawl/7/", run.AutomationDetails.Id)
+	}
+	rules := make(map[string]string)
+	for _, rule := range run.Tool.Driver.Rules {
+		rules[rule.Id] = rule.DefaultConfiguration.Level
+	}
+	if len(rules) != len(sarifRules) || rules[schemas.ErrorClassSoft404] != "warning" || rules[schemas.ErrorClassMissingAnchor] != "error" {
+		t.Errorf("rules = %v, want every error class, soft 404s as warnings", rules)
+	}
+
+	tests := []struct {
+		url       string
+		ruleId    string
+		level     string
+		locations []string
+	}{
+		{"https://example.com/gone", schemas.ErrorClassClient, "error", []string{"https://example.com/", "https://example.com/about"}},
+		{"https://example.com/empty", schemas.ErrorClassSoft404, "warning", []string{"https://example.com/empty"}},
+		{"https://example.com/doc#intro", schemas.ErrorClassMissingAnchor, "error", []string{"https://example.com/"}},
+	}
+	if len(run.Results) != len(tests) {
+		t.Fatalf("%d results, want one per broken URL, %d", len(run.Results), len(tests))
+	}
+	for i, test := range tests {
+		result := run.Results[i]
+		locations := make([]string, 0, len(result.Locations))
+		for _, location := range result.Locations {
+			locations = append(locations, location.PhysicalLocation.ArtifactLocation.Uri)
+		}
+		if result.RuleId != test.ruleId || result.Level != test.level || result.PartialFingerprints["brokenUrl/v1"] != test.url ||
+			!slices.Equal(locations, test.locations) {
+			t.Errorf("result %d = %+v at %q, want rule %s at level %s for %s at %q",
+				i, result, locations, test.ruleId, test.level, test.url, test.locations)
+		}
+	}
+}
+
+func TestSarifExporterLocationsBounded(t *testing.T) {
+	var output bytes.Buffer
+	exporter := &sarifExporter{w: &output}
+	result := schemas.CrawlResult{Url: "https://example.com/gone", Broken: true, ErrorClass: schemas.ErrorClassClient}
+	for i := 0; i < sarifMaxLocations+5; i++ {
+		result.Referrers = append(result.Referrers, "https://example.com/"+strings.Repeat("a", i))
+	}
+	if err := exporter.Export(result); err != nil {
+		t.Fatal(err)
+	}
+	var exported sarifResult
+	if err := json.Unmarshal(output.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Locations) != sarifMaxLocations || !strings.Contains(exported.Message.Text, "linked from 15 pages") {
+		t.Errorf("%d locations with message %q, want %d and the number of pages", len(exported.Locations), exported.Message.Text, sarifMaxLocations)
+	}
+}
@@ -0,0 +1,1032 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/tools"
+)
+
+const (
+	defaultCrawlDepth = 3
+	defaultCrawlPages = 1000
+	maxRunningCrawls  = 2
+	crawlQueueSize    = 1024
+
+	defaultRequestTimeout     = 30
+	defaultRedirectChainLimit = 3
+	defaultFrontierMemory     = 100000
+)
+
+var (
+	ErrCrawlJobNotFound = errors.New("crawl job not found")
+	ErrCrawlQueueFull   = errors.New("too many crawls are already queued")
+	ErrCrawlJobState    = errors.New("invalid crawl job state")
+	ErrNoPreviousCrawl  = errors.New("no previous crawl of the site to compare with")
+
+	// Causes of the interruption of a running crawl
+	errCrawlCancelled = errors.New("crawl cancelled")
+	errCrawlPaused    = errors.New("crawl paused")
+	errCrawlTimedOut  = errors.New("crawl deadline exceeded")
+)
+
+type CrawlJobService interface {
+	Enqueue(user RequestUser, request schemas.CrawlRequest) (schemas.CrawlJob, error)
+	// The crawls of the others are reported as not found, unless user is
+	// an admin.
+	GetJob(user RequestUser, id uint64) (schemas.CrawlJob, error)
+	GetBrokenLinks(user RequestUser, id uint64) ([]schemas.BrokenLink, error)
+	GetSuspectLinks(user RequestUser, id uint64) ([]schemas.BrokenLink, error)
+	GetSitemapReport(user RequestUser, id uint64) (schemas.SitemapReport, error)
+	GetRedirects(user RequestUser, id uint64) ([]schemas.RedirectReport, error)
+	GetDiff(user RequestUser, id uint64, against uint64) (schemas.CrawlDiff, error)
+	Export(user RequestUser, id uint64, format string, brokenOnly bool, w io.Writer) error
+	Cancel(user RequestUser, id uint64) (schemas.CrawlJob, error)
+	Pause(user RequestUser, id uint64) (schemas.CrawlJob, error)
+	Resume(user RequestUser, id uint64) (schemas.CrawlJob, error)
+	// findJob returns a crawl whoever queued it, for the services following it.
+	findJob(id uint64) (schemas.CrawlJob, error)
+}
+
+type crawlJobService struct {
+	repository         repository.CrawlJobRepository
+	linkRepository     repository.LinkRepository
+	edgeRepository     repository.CrawlEdgeRepository
+	eventRepository    repository.CrawlEventRepository
+	frontierRepository repository.CrawlFrontierRepository
+	sitemapRepository  repository.CrawlSitemapRepository
+	visitedRepository  repository.CrawlVisitedRepository
+	// Queue of the crawls run by the workers, nil when they are run here
+	workRepository    repository.CrawlWorkRepository
+	projectRepository repository.ProjectRepository
+	linkStates        LinkStateService
+	crawlResources
+	queue chan uint64
+
+	// mu serializes the state transitions of the jobs.
+	mu      sync.Mutex
+	running map[uint64]context.CancelCauseFunc
+}
+
+// crawlResources are shared by the crawls run by a process, so that the
+// limits of a host apply to all of them.
+type crawlResources struct {
+	fetcher    Fetcher
+	robots     *RobotsCache
+	soft404    *Soft404Detector
+	sitemaps   *SitemapReader
+	politeness *Politeness
+}
+
+func newCrawlResources(fetcher Fetcher) crawlResources {
+	return crawlResources{
+		fetcher:    fetcher,
+		robots:     NewRobotsCache(),
+		soft404:    NewSoft404Detector(fetcher),
+		sitemaps:   NewSitemapReader(),
+		politeness: NewPoliteness(),
+	}
+}
+
+// NewCrawlJobService starts the crawl runners and requeues the jobs left
+// queued by a previous run of the server. When crawlWorkRepository is not
+// nil, the runners only queue the URLs of the crawls for the workers.
+func NewCrawlJobService(
+	crawlJobRepository repository.CrawlJobRepository,
+	linkRepository repository.LinkRepository,
+	crawlEdgeRepository repository.CrawlEdgeRepository,
+	crawlEventRepository repository.CrawlEventRepository,
+	crawlFrontierRepository repository.CrawlFrontierRepository,
+	crawlSitemapRepository repository.CrawlSitemapRepository,
+	crawlVisitedRepository repository.CrawlVisitedRepository,
+	crawlWorkRepository repository.CrawlWorkRepository,
+	projectRepository repository.ProjectRepository,
+	linkStateService LinkStateService,
+	fetcher Fetcher,
+) CrawlJobService {
+	service := &crawlJobService{
+		repository:         crawlJobRepository,
+		linkRepository:     linkRepository,
+		edgeRepository:     crawlEdgeRepository,
+		eventRepository:    crawlEventRepository,
+		frontierRepository: crawlFrontierRepository,
+		sitemapRepository:  crawlSitemapRepository,
+		visitedRepository:  crawlVisitedRepository,
+		workRepository:     crawlWorkRepository,
+		projectRepository:  projectRepository,
+		linkStates:         linkStateService,
+		crawlResources:     newCrawlResources(fetcher),
+		queue:              make(chan uint64, crawlQueueSize),
+		running:            make(map[uint64]context.CancelCauseFunc),
+	}
+	service.recoverJobs()
+	for i := 0; i < maxRunningCrawls; i++ {
+		go service.runner()
+	}
+	return service
+}
+
+// Enqueue queues a crawl on behalf of user, of one of the projects of user
+// when request names one.
+func (service *crawlJobService) Enqueue(user RequestUser, request schemas.CrawlRequest) (schemas.CrawlJob, error) {
+	job := schemas.CrawlJob{
+		SeedUrl:  request.Url,
+		Settings: request.CrawlSettings,
+		Status:   schemas.CrawlJobQueued,
+		OwnerId:  user.Id,
+	}
+	// robots.txt may only be ignored for the verified projects.
+	job.Settings.IgnoreRobots = false
+	if request.ProjectId != 0 {
+		project, err := service.projectRepository.FindById(request.ProjectId)
+		// The projects of the others are reported as not found.
+		if err != nil || (!user.Admin && project.OwnerId != user.Id) {
+			return schemas.CrawlJob{}, ErrProjectNotFound
+		}
+		job.ProjectId = project.Id
+		if job.SeedUrl == "" {
+			job.SeedUrl = project.Url
+		}
+		job.Settings = mergeCrawlSettings(project.Settings, job.Settings)
+		// Projects saved before they had to be verified may still ask for it.
+		if !project.Verified {
+			job.Settings.IgnoreRobots = false
+		}
+		if job.Settings.IgnoreRobots && !onProjectSite(project, job) {
+			return schemas.CrawlJob{}, ErrIgnoreRobotsHost
+		}
+	}
+
+	_, err := CompileSoft404Patterns(job.Settings.Soft404TitlePatterns, job.Settings.Soft404BodyPatterns)
+	if err != nil {
+		return schemas.CrawlJob{}, fmt.Errorf("invalid soft 404 pattern: %w", err)
+	}
+	if _, err := NewScope(job.Settings.Scope); err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	if len(job.Settings.Scope.AllowedHosts) == 0 {
+		job.Settings.Scope.AllowedHosts = seedHosts(job.SeedUrl)
+	}
+	if job.Settings.Scope.External == "" {
+		job.Settings.Scope.External = schemas.ExternalCheck
+	}
+	if job.Settings.Scope.Query == "" {
+		job.Settings.Scope.Query = schemas.QueryKeep
+	}
+	if job.Settings.MaxDepth == 0 {
+		job.Settings.MaxDepth = defaultCrawlDepth
+	}
+	if job.Settings.MaxPages == 0 {
+		job.Settings.MaxPages = defaultCrawlPages
+	}
+	if job.Settings.Workers == 0 {
+		job.Settings.Workers = defaultCrawlWorkers
+	}
+	if job.Settings.RequestTimeout == 0 {
+		job.Settings.RequestTimeout = defaultRequestTimeout
+	}
+	if job.Settings.RedirectChainLimit == 0 {
+		job.Settings.RedirectChainLimit = defaultRedirectChainLimit
+	}
+	if job.Settings.RetryAttempts == 0 {
+		job.Settings.RetryAttempts = defaultRetryAttempts
+	}
+	if job.Settings.RetryBackoff == 0 {
+		job.Settings.RetryBackoff = int(defaultRetryBackoff / time.Millisecond)
+	}
+	if job.Settings.BrokenAfterFailures == 0 {
+		job.Settings.BrokenAfterFailures = defaultBrokenAfterFailures
+	}
+	if job.Settings.RecoveredAfterSuccesses == 0 {
+		job.Settings.RecoveredAfterSuccesses = defaultRecoveredAfterSuccesses
+	}
+	if job.Settings.CheckMethod == "" {
+		job.Settings.CheckMethod = schemas.CheckMethodHead
+	}
+	if job.Settings.NoFollow == "" {
+		job.Settings.NoFollow = schemas.NoFollowCheck
+	}
+	if job.Settings.HostConcurrency == 0 {
+		job.Settings.HostConcurrency = defaultHostConcurrency
+	}
+	if job.Settings.HostRequestsPerSecond == 0 {
+		job.Settings.HostRequestsPerSecond = defaultHostRequestsPerSecond
+	}
+	if job.Settings.TrapSegmentRepeats == 0 {
+		job.Settings.TrapSegmentRepeats = defaultTrapSegmentRepeats
+	}
+	if job.Settings.TrapQueryCombinations == 0 {
+		job.Settings.TrapQueryCombinations = defaultTrapQueryCombinations
+	}
+	if job.Settings.TrapPatternUrls == 0 {
+		job.Settings.TrapPatternUrls = defaultTrapPatternUrls
+	}
+	if job.Settings.FrontierMemory == 0 {
+		job.Settings.FrontierMemory = defaultFrontierMemory
+	}
+	job.Id = service.repository.Save(job)
+
+	select {
+	case service.queue <- job.Id:
+	default:
+		service.finish(job.Id, schemas.CrawlJobFailed, ErrCrawlQueueFull)
+		return schemas.CrawlJob{}, ErrCrawlQueueFull
+	}
+	return service.findJob(job.Id)
+}
+
+func (service *crawlJobService) GetJob(user RequestUser, id uint64) (schemas.CrawlJob, error) {
+	job, err := service.findJob(id)
+	if err != nil || !ownsCrawl(user, job) {
+		return schemas.CrawlJob{}, ErrCrawlJobNotFound
+	}
+	return job, nil
+}
+
+// ownsCrawl tells whether user may see and control job.
+func ownsCrawl(user RequestUser, job schemas.CrawlJob) bool {
+	return user.Admin || job.OwnerId == user.Id
+}
+
+func (service *crawlJobService) findJob(id uint64) (schemas.CrawlJob, error) {
+	job, err := service.repository.FindById(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return schemas.CrawlJob{}, ErrCrawlJobNotFound
+	}
+	if job.Traps == nil {
+		job.Traps = []schemas.CrawlTrap{}
+	}
+	if job.StartedAt != nil {
+		end := time.Now()
+		if job.FinishedAt != nil {
+			end = *job.FinishedAt
+		}
+		job.Elapsed = end.Sub(*job.StartedAt).Seconds()
+	}
+	return job, nil
+}
+
+// GetBrokenLinks returns the links a crawl confirmed broken, each one with
+// the pages referencing it, followed by the links to missing anchors.
+func (service *crawlJobService) GetBrokenLinks(user RequestUser, id uint64) ([]schemas.BrokenLink, error) {
+	_, err := service.GetJob(user, id)
+	if err != nil {
+		return nil, err
+	}
+	brokenLinks := service.linkReport(id, service.linkRepository.FindBrokenByCrawlJob(id))
+	return append(brokenLinks, service.missingAnchors(id)...), nil
+}
+
+// GetSuspectLinks returns the links which failed during a crawl without
+// being confirmed broken yet, each one with the pages referencing it.
+func (service *crawlJobService) GetSuspectLinks(user RequestUser, id uint64) ([]schemas.BrokenLink, error) {
+	_, err := service.GetJob(user, id)
+	if err != nil {
+		return nil, err
+	}
+	return service.linkReport(id, service.linkRepository.FindSuspectByCrawlJob(id)), nil
+}
+
+// linkReport describes the failed links of a crawl along with the pages
+// referencing them and their state.
+func (service *crawlJobService) linkReport(id uint64, links []schemas.Link) []schemas.BrokenLink {
+	brokenLinks := make([]schemas.BrokenLink, 0, len(links))
+	urls := make([]string, 0, len(links))
+	index := make(map[string]int, len(links))
+	for _, link := range links {
+		index[link.UrlId.Url] = len(brokenLinks)
+		urls = append(urls, link.UrlId.Url)
+		category := schemas.BrokenLinkHttpError
+		switch {
+		case link.ErrorClass == schemas.ErrorClassSoft404:
+			category = schemas.BrokenLinkSoft404
+		case link.StatusCode == 0:
+			category = schemas.BrokenLinkFetchError
+		}
+		brokenLinks = append(brokenLinks, schemas.BrokenLink{
+			Url:        link.UrlId.Url,
+			Category:   category,
+			ErrorClass: link.ErrorClass,
+			Attempts:   link.Attempts,
+			StatusCode: link.StatusCode,
+			Response:   link.Response,
+			Referrers:  []schemas.CrawlEdge{},
+		})
+	}
+	for _, edge := range service.edgeRepository.FindByTargets(id, urls) {
+		i := index[edge.TargetUrl]
+		brokenLinks[i].Referrers = append(brokenLinks[i].Referrers, edge)
+	}
+	linkIds := make([]uint64, 0, len(links))
+	for _, link := range links {
+		linkIds = append(linkIds, link.LinkId)
+	}
+	states := service.linkStates.FindByLinks(linkIds)
+	for i, link := range links {
+		brokenLinks[i].State = states[link.LinkId].State
+	}
+	return brokenLinks
+}
+
+// missingAnchors returns the fragment links pointing at an anchor absent
+// from their target page.
+func (service *crawlJobService) missingAnchors(id uint64) []schemas.BrokenLink {
+	edges := service.edgeRepository.FindWithFragment(id)
+	targets := make([]string, 0, len(edges))
+	seen := make(map[string]bool)
+	for _, edge := range edges {
+		if !seen[edge.TargetUrl] {
+			seen[edge.TargetUrl] = true
+			targets = append(targets, edge.TargetUrl)
+		}
+	}
+
+	anchors := make(map[string]map[string]bool)
+	for _, link := range service.linkRepository.FindByCrawlJobAndUrls(id, targets) {
+		// Only the HTML pages fetched successfully have anchors to check.
+		if link.Broken || link.Anchors == nil {
+			continue
+		}
+		pageAnchors := make(map[string]bool, len(link.Anchors))
+		for _, anchor := range link.Anchors {
+			pageAnchors[anchor] = true
+		}
+		anchors[link.UrlId.Url] = pageAnchors
+	}
+
+	missing := []schemas.BrokenLink{}
+	index := make(map[string]int)
+	for _, edge := range edges {
+		pageAnchors, checked := anchors[edge.TargetUrl]
+		if !checked || pageAnchors[edge.Fragment] || !checkableFragment(edge.Fragment) {
+			continue
+		}
+		url := edge.TargetUrl + "#" + edge.Fragment
+		i, found := index[url]
+		if !found {
+			i = len(missing)
+			index[url] = i
+			missing = append(missing, schemas.BrokenLink{
+				Url:       url,
+				Category:  schemas.BrokenLinkMissingAnchor,
+				Response:  fmt.Sprintf("no element with the id or name %q", edge.Fragment),
+				Referrers: []schemas.CrawlEdge{},
+			})
+		}
+		missing[i].Referrers = append(missing[i].Referrers, edge)
+	}
+	return missing
+}
+
+// checkableFragment tells whether a fragment should name an anchor, unlike
+// #top, hash-bang routes or text fragments.
+func checkableFragment(fragment string) bool {
+	switch {
+	case strings.EqualFold(fragment, "top"):
+		return false
+	case strings.HasPrefix(fragment, "!"), strings.HasPrefix(fragment, "/"), strings.Contains(fragment, ":~:"):
+		return false
+	}
+	return true
+}
+
+// GetSitemapReport compares the sitemaps of the site of a crawl with the
+// pages it checked.
+func (service *crawlJobService) GetSitemapReport(user RequestUser, id uint64) (schemas.SitemapReport, error) {
+	job, err := service.GetJob(user, id)
+	if err != nil {
+		return schemas.SitemapReport{}, err
+	}
+
+	report := schemas.SitemapReport{
+		Sitemaps:           []string{},
+		Broken:             []schemas.SitemapIssue{},
+		Redirected:         []schemas.SitemapIssue{},
+		MissingFromSitemap: []string{},
+	}
+	listed := make(map[string]string)
+	for _, sitemapUrl := range service.sitemapRepository.FindByCrawlJob(id) {
+		if _, found := listed[sitemapUrl.Url]; found {
+			continue
+		}
+		listed[sitemapUrl.Url] = sitemapUrl.Sitemap
+		if len(report.Sitemaps) == 0 || report.Sitemaps[len(report.Sitemaps)-1] != sitemapUrl.Sitemap {
+			report.Sitemaps = append(report.Sitemaps, sitemapUrl.Sitemap)
+		}
+	}
+	report.Urls = len(listed)
+
+	seed, _ := url.Parse(tools.NormalizeUrl(job.SeedUrl))
+	for _, link := range service.linkRepository.FindByCrawlJob(id) {
+		sitemap, isListed := listed[link.UrlId.Url]
+		issue := schemas.SitemapIssue{
+			Url:         link.UrlId.Url,
+			Sitemap:     sitemap,
+			StatusCode:  link.StatusCode,
+			Response:    link.Response,
+			RedirectUrl: link.RedirectUrl,
+		}
+		switch {
+		case isListed && link.Broken:
+			report.Broken = append(report.Broken, issue)
+		case isListed && link.RedirectUrl != "":
+			report.Redirected = append(report.Redirected, issue)
+		case !isListed && len(listed) > 0 && !link.Broken && !link.Suspect && link.RedirectUrl == "" && link.StatusCode == http.StatusOK:
+			// Only the pages of the site are expected in its sitemaps.
+			if target, err := url.Parse(link.UrlId.Url); err == nil && seed != nil && target.Host == seed.Host {
+				report.MissingFromSitemap = append(report.MissingFromSitemap, link.UrlId.Url)
+			}
+		}
+	}
+	return report, nil
+}
+
+// GetRedirects returns the URLs checked by a crawl which were redirected,
+// flagging loops, long chains and downgrades to http, and suggesting to
+// update the links whose redirects are all permanent.
+func (service *crawlJobService) GetRedirects(user RequestUser, id uint64) ([]schemas.RedirectReport, error) {
+	job, err := service.GetJob(user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	links := service.linkRepository.FindRedirectedByCrawlJob(id)
+	reports := make([]schemas.RedirectReport, 0, len(links))
+	urls := make([]string, 0, len(links))
+	index := make(map[string]int, len(links))
+	for _, link := range links {
+		report := schemas.RedirectReport{
+			Url:        link.UrlId.Url,
+			FinalUrl:   link.RedirectUrl,
+			StatusCode: link.StatusCode,
+			Chain:      link.Redirects,
+			TooLong:    len(link.Redirects) > job.Settings.RedirectChainLimit,
+			Referrers:  []schemas.CrawlEdge{},
+		}
+		permanent := !link.Broken && !link.Suspect
+		for i, hop := range link.Redirects {
+			next := link.RedirectUrl
+			if i+1 < len(link.Redirects) {
+				next = link.Redirects[i+1].Url
+			}
+			if strings.HasPrefix(hop.Url, "https:") && strings.HasPrefix(next, "http:") {
+				report.Downgrade = true
+			}
+			if hop.Url == link.RedirectUrl {
+				report.Loop = true
+			}
+			if hop.StatusCode != http.StatusMovedPermanently && hop.StatusCode != http.StatusPermanentRedirect {
+				permanent = false
+			}
+		}
+		if permanent && !report.Loop {
+			report.Suggestion = "update this link to " + link.RedirectUrl
+		}
+		index[report.Url] = len(reports)
+		urls = append(urls, report.Url)
+		reports = append(reports, report)
+	}
+	for _, edge := range service.edgeRepository.FindByTargets(id, urls) {
+		i := index[edge.TargetUrl]
+		reports[i].Referrers = append(reports[i].Referrers, edge)
+	}
+	return reports, nil
+}
+
+// GetDiff compares the URLs checked by a crawl with the ones checked by the
+// crawl against, the previous run of its site when 0. Both crawls must be
+// finished.
+func (service *crawlJobService) GetDiff(user RequestUser, id uint64, against uint64) (schemas.CrawlDiff, error) {
+	job, err := service.GetJob(user, id)
+	if err != nil {
+		return schemas.CrawlDiff{}, err
+	}
+	var other schemas.CrawlJob
+	if against == 0 {
+		other, err = service.repository.FindPreviousRun(job)
+		if errors.Is(err, repository.ErrNotFound) {
+			return schemas.CrawlDiff{}, ErrNoPreviousCrawl
+		}
+	} else {
+		other, err = service.GetJob(user, against)
+		if err != nil {
+			return schemas.CrawlDiff{}, err
+		}
+	}
+	if !crawlFinished(job) || !crawlFinished(other) {
+		return schemas.CrawlDiff{}, fmt.Errorf("%w: only finished crawls are compared", ErrCrawlJobState)
+	}
+
+	diff := schemas.CrawlDiff{
+		CrawlJobId:    job.Id,
+		AgainstId:     other.Id,
+		NewlyBroken:   []schemas.CrawlDiffUrl{},
+		Fixed:         []schemas.CrawlDiffUrl{},
+		NewPages:      []schemas.CrawlDiffUrl{},
+		RemovedPages:  []schemas.CrawlDiffUrl{},
+		StatusChanges: []schemas.CrawlDiffUrl{},
+		ChangedPages:  []schemas.CrawlDiffUrl{},
+	}
+	previous := checkedLinks(service.linkRepository.FindByCrawlJob(other.Id))
+	current := checkedLinks(service.linkRepository.FindByCrawlJob(job.Id))
+	for _, url := range sortedKeys(current) {
+		link := current[url]
+		before, found := previous[url]
+		entry := schemas.CrawlDiffUrl{
+			Url:        url,
+			StatusCode: link.StatusCode,
+			Response:   link.Response,
+			ErrorClass: link.ErrorClass,
+		}
+		if found {
+			entry.PreviousStatusCode = before.StatusCode
+			entry.PreviousResponse = before.Response
+			entry.PreviousErrorClass = before.ErrorClass
+		}
+		switch {
+		case !found:
+			diff.NewPages = append(diff.NewPages, entry)
+		case link.StatusCode != before.StatusCode:
+			diff.StatusChanges = append(diff.StatusChanges, entry)
+		}
+		switch {
+		case link.Broken && (!found || !before.Broken):
+			diff.NewlyBroken = append(diff.NewlyBroken, entry)
+		case !link.Broken && found && before.Broken:
+			diff.Fixed = append(diff.Fixed, entry)
+		}
+		if found && link.ContentHash != "" && before.ContentHash != "" && link.ContentHash != before.ContentHash {
+			diff.ChangedPages = append(diff.ChangedPages, entry)
+		}
+	}
+	for _, url := range sortedKeys(previous) {
+		if _, found := current[url]; !found {
+			before := previous[url]
+			diff.RemovedPages = append(diff.RemovedPages, schemas.CrawlDiffUrl{
+				Url:                url,
+				PreviousStatusCode: before.StatusCode,
+				PreviousResponse:   before.Response,
+				PreviousErrorClass: before.ErrorClass,
+			})
+		}
+	}
+
+	urls := make([]string, 0, len(diff.NewlyBroken))
+	index := make(map[string]int, len(diff.NewlyBroken))
+	for i, entry := range diff.NewlyBroken {
+		index[entry.Url] = i
+		urls = append(urls, entry.Url)
+	}
+	for _, edge := range service.edgeRepository.FindByTargets(job.Id, urls) {
+		i := index[edge.TargetUrl]
+		diff.NewlyBroken[i].Referrers = append(diff.NewlyBroken[i].Referrers, edge)
+	}
+	return diff, nil
+}
+
+func crawlFinished(job schemas.CrawlJob) bool {
+	switch job.Status {
+	case schemas.CrawlJobSucceeded, schemas.CrawlJobFailed, schemas.CrawlJobCancelled:
+		return true
+	}
+	return false
+}
+
+// checkedLinks indexes the last check of every URL by URL, leaving out the
+// ones robots.txt kept the crawl from checking.
+func checkedLinks(links []schemas.Link) map[string]schemas.Link {
+	checked := make(map[string]schemas.Link, len(links))
+	for _, link := range links {
+		if skippedLink(link) {
+			continue
+		}
+		checked[link.UrlId.Url] = link
+	}
+	return checked
+}
+
+// skippedLink tells whether a URL was not checked, robots.txt disallowing it.
+func skippedLink(link schemas.Link) bool {
+	return link.StatusCode == 0 && !link.Broken && !link.Suspect
+}
+
+func sortedKeys(links map[string]schemas.Link) []string {
+	keys := make([]string, 0, len(links))
+	for key := range links {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Cancel stops a crawl for good, dropping its frontier.
+func (service *crawlJobService) Cancel(user RequestUser, id uint64) (schemas.CrawlJob, error) {
+	service.mu.Lock()
+	job, err := service.repository.FindById(id)
+	if err != nil || !ownsCrawl(user, job) {
+		service.mu.Unlock()
+		return schemas.CrawlJob{}, ErrCrawlJobNotFound
+	}
+	switch job.Status {
+	case schemas.CrawlJobRunning:
+		if cancel, found := service.running[id]; found {
+			// The runner records the cancellation once the crawl stopped.
+			cancel(errCrawlCancelled)
+		} else {
+			// Crawled by the workers, which skip the items of the jobs not running
+			service.workRepository.DeleteByCrawlJob(id)
+			service.finish(job.Id, schemas.CrawlJobCancelled, nil)
+		}
+	case schemas.CrawlJobQueued, schemas.CrawlJobPaused:
+		service.frontierRepository.DeleteByCrawlJob(id)
+		service.finish(job.Id, schemas.CrawlJobCancelled, nil)
+	default:
+		err = fmt.Errorf("%w: crawl job is already %s", ErrCrawlJobState, job.Status)
+	}
+	service.mu.Unlock()
+
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return service.findJob(id)
+}
+
+// Pause stops a running crawl, keeping its frontier so it can be resumed.
+func (service *crawlJobService) Pause(user RequestUser, id uint64) (schemas.CrawlJob, error) {
+	service.mu.Lock()
+	job, err := service.repository.FindById(id)
+	if err != nil || !ownsCrawl(user, job) {
+		service.mu.Unlock()
+		return schemas.CrawlJob{}, ErrCrawlJobNotFound
+	}
+	if cancel, found := service.running[id]; found && job.Status == schemas.CrawlJobRunning {
+		// The runner saves the frontier once the crawl stopped.
+		cancel(errCrawlPaused)
+	} else if job.Status == schemas.CrawlJobRunning {
+		// Crawled by the workers, the frontier staying in their queue
+		service.repository.UpdateStatus(id, schemas.CrawlJobRunning, schemas.CrawlJobPaused)
+	} else {
+		err = fmt.Errorf("%w: only a running crawl job can be paused, it is %s", ErrCrawlJobState, job.Status)
+	}
+	service.mu.Unlock()
+
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return service.findJob(id)
+}
+
+// Resume queues again a paused crawl, which carries on from its saved frontier.
+func (service *crawlJobService) Resume(user RequestUser, id uint64) (schemas.CrawlJob, error) {
+	service.mu.Lock()
+	job, err := service.repository.FindById(id)
+	if err != nil || !ownsCrawl(user, job) {
+		service.mu.Unlock()
+		return schemas.CrawlJob{}, ErrCrawlJobNotFound
+	}
+	if job.Status == schemas.CrawlJobPaused {
+		select {
+		case service.queue <- job.Id:
+			// The runner waits for mu, and so for the job to be queued.
+			service.repository.UpdateStatus(job.Id, schemas.CrawlJobPaused, schemas.CrawlJobQueued)
+		default:
+			err = ErrCrawlQueueFull
+		}
+	} else {
+		err = fmt.Errorf("%w: only a paused crawl job can be resumed, it is %s", ErrCrawlJobState, job.Status)
+	}
+	service.mu.Unlock()
+
+	if err != nil {
+		return schemas.CrawlJob{}, err
+	}
+	return service.findJob(id)
+}
+
+// recoverJobs fails the crawls interrupted by a restart and queues again
+// the ones that never started.
+func (service *crawlJobService) recoverJobs() {
+	// The workers carry on with the running crawls on their own.
+	if service.workRepository == nil {
+		for _, job := range service.repository.FindByStatus(schemas.CrawlJobRunning) {
+			service.finish(job.Id, schemas.CrawlJobFailed, errors.New("interrupted by a server restart"))
+		}
+	}
+	for _, job := range service.repository.FindByStatus(schemas.CrawlJobQueued) {
+		select {
+		case service.queue <- job.Id:
+		default:
+			service.finish(job.Id, schemas.CrawlJobFailed, ErrCrawlQueueFull)
+		}
+	}
+}
+
+func (service *crawlJobService) runner() {
+	for id := range service.queue {
+		service.run(id)
+	}
+}
+
+func (service *crawlJobService) run(id uint64) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	job, ok := service.start(id, cancel)
+	if !ok {
+		return
+	}
+	defer func() {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		delete(service.running, job.Id)
+		if r := recover(); r != nil {
+			service.finish(job.Id, schemas.CrawlJobFailed, fmt.Errorf("crawl aborted: %v", r))
+		}
+	}()
+
+	if service.workRepository != nil {
+		service.queueWork(ctx, job)
+		return
+	}
+
+	state := CrawlState{Pending: []frontierItem{{Url: job.SeedUrl}}}
+	if items := service.frontierRepository.FindByCrawlJob(job.Id); len(items) > 0 {
+		state = CrawlState{}
+		for _, item := range items {
+			if item.Fetched {
+				state.Visited = append(state.Visited, item.Url)
+			} else {
+				state.Pending = append(state.Pending, frontierItem{Url: item.Url, Depth: item.Depth, Leaf: item.Leaf})
+			}
+		}
+		service.frontierRepository.DeleteByCrawlJob(job.Id)
+	} else if !job.Settings.IgnoreSitemaps {
+		state.Pending = append(state.Pending, service.readSitemaps(ctx, job)...)
+	}
+	if job.Settings.Timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, time.Duration(job.Settings.Timeout)*time.Second, errCrawlTimedOut)
+		defer stop()
+	}
+
+	recorder := newCrawlRecorder(job, service.repository, service.linkRepository, service.edgeRepository, service.eventRepository, service.linkStates)
+	var visited VisitedStore
+	if job.Settings.MaxPages > visitedMemoryLimit {
+		visited = NewCrawlVisitedStore(job.Id, service.visitedRepository)
+	}
+	history := pageHistory(job, service.linkRepository, service.edgeRepository)
+	crawler := service.crawler(job, visited, history, recorder)
+	left, err := crawler.Resume(ctx, state)
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	delete(service.running, job.Id)
+
+	// The counters were kept up to date by the recorder.
+	if traps := crawler.Traps(); len(traps) > 0 {
+		service.repository.UpdateTraps(job.Id, func(known []schemas.CrawlTrap) []schemas.CrawlTrap {
+			return mergeTraps(known, traps)
+		})
+	}
+	switch seedErr := recorder.seedError(); {
+	case errors.Is(err, errCrawlPaused):
+		service.saveFrontier(job.Id, left)
+		service.repository.UpdateStatus(job.Id, schemas.CrawlJobRunning, schemas.CrawlJobPaused)
+	case errors.Is(err, errCrawlCancelled):
+		service.finish(job.Id, schemas.CrawlJobCancelled, nil)
+	case err != nil:
+		service.finish(job.Id, schemas.CrawlJobFailed, err)
+	case seedErr != nil:
+		service.finish(job.Id, schemas.CrawlJobFailed, seedErr)
+	default:
+		service.finish(job.Id, schemas.CrawlJobSucceeded, nil)
+	}
+}
+
+// crawler returns the crawler of a job, reporting its pages to observer.
+func (resources crawlResources) crawler(job schemas.CrawlJob, visited VisitedStore, history PageHistory, observer CrawlObserver) *Crawler {
+	options := CrawlOptions{
+		Workers:        job.Settings.Workers,
+		MaxDepth:       job.Settings.MaxDepth,
+		MaxPages:       job.Settings.MaxPages,
+		RequestTimeout: time.Duration(job.Settings.RequestTimeout) * time.Second,
+		Politeness:     resources.politeness,
+		ResourceKinds:  job.Settings.ResourceKinds,
+		NoFollow:       job.Settings.NoFollow,
+		Normalizer:     urlNormalizer(job.Settings),
+		FrontierMemory: job.Settings.FrontierMemory,
+		Visited:        visited,
+		History:        history,
+		AlwaysGet:      job.Settings.CheckMethod == schemas.CheckMethodGet,
+		MaxBodySize:    job.Settings.MaxBodySize,
+		Retry: RetryPolicy{
+			MaxAttempts: job.Settings.RetryAttempts,
+			Backoff:     time.Duration(job.Settings.RetryBackoff) * time.Millisecond,
+		},
+		HostPolicy: HostPolicy{
+			MaxConcurrency:    job.Settings.HostConcurrency,
+			RequestsPerSecond: job.Settings.HostRequestsPerSecond,
+		},
+	}
+	if !job.Settings.IgnoreRobots {
+		options.Robots = resources.robots
+	}
+	// The scope was checked when the job was enqueued.
+	options.Scope, _ = NewScope(job.Settings.Scope)
+	if !job.Settings.DisableTrapDetection {
+		options.Traps = TrapPolicy{
+			SegmentRepeats:    job.Settings.TrapSegmentRepeats,
+			QueryCombinations: job.Settings.TrapQueryCombinations,
+			PatternUrls:       job.Settings.TrapPatternUrls,
+		}
+	}
+	if !job.Settings.DisableSoft404 {
+		// The patterns were checked when the job was enqueued.
+		options.Soft404Patterns, _ = CompileSoft404Patterns(job.Settings.Soft404TitlePatterns, job.Settings.Soft404BodyPatterns)
+		options.Soft404 = resources.soft404
+	}
+	return NewCrawler(resources.fetcher, options, observer)
+}
+
+// queueWork queues the seed of a crawl run by the workers along with the
+// URLs of the sitemaps of its site, unless it is resumed. The job is paused
+// or cancelled here if it was meanwhile, and finished if nothing is left to
+// fetch, having been paused as its last URL was fetched.
+func (service *crawlJobService) queueWork(ctx context.Context, job schemas.CrawlJob) {
+	queued := service.workRepository.Remaining(job.Id)
+	if queued == 0 {
+		pending := []frontierItem{{Url: job.SeedUrl}}
+		if !job.Settings.IgnoreSitemaps {
+			pending = append(pending, service.readSitemaps(ctx, job)...)
+		}
+		crawler := service.crawler(job, nil, nil, nil)
+		items := make([]schemas.CrawlWorkItem, 0, len(pending))
+		seen := make(map[int64]bool, len(pending))
+		for _, item := range pending {
+			item.Url = crawler.normalize(item.Url)
+			hash := int64(urlHash(item.Url))
+			if seen[hash] || !crawler.admit(&item) {
+				continue
+			}
+			seen[hash] = true
+			items = append(items, newCrawlWorkItem(job.Id, item))
+		}
+		if ctx.Err() == nil {
+			queued = service.workRepository.Push(items, job.Settings.MaxPages)
+		}
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	delete(service.running, job.Id)
+	switch err := context.Cause(ctx); {
+	case errors.Is(err, errCrawlPaused):
+		service.repository.UpdateStatus(job.Id, schemas.CrawlJobRunning, schemas.CrawlJobPaused)
+	case errors.Is(err, errCrawlCancelled):
+		service.workRepository.DeleteByCrawlJob(job.Id)
+		service.finish(job.Id, schemas.CrawlJobCancelled, nil)
+	case queued == 0:
+		service.workRepository.DeleteByCrawlJob(job.Id)
+		service.finish(job.Id, schemas.CrawlJobSucceeded, nil)
+	}
+}
+
+// start marks a queued job as running, registering how to interrupt it.
+func (service *crawlJobService) start(id uint64, cancel context.CancelCauseFunc) (schemas.CrawlJob, bool) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	job, err := service.repository.FindById(id)
+	if err != nil {
+		log.Println("crawl job", id, err)
+		return job, false
+	}
+	// Cancelled while waiting in the queue
+	if job.Status != schemas.CrawlJobQueued {
+		return job, false
+	}
+
+	startedAt := time.Now()
+	if !service.repository.Start(id, startedAt) {
+		return job, false
+	}
+	if job.StartedAt == nil {
+		job.StartedAt = &startedAt
+	}
+	job.Status = schemas.CrawlJobRunning
+	service.running[id] = cancel
+	return job, true
+}
+
+// readSitemaps records the URLs listed by the sitemaps of the site of a
+// crawl and returns them to be fetched as if linked from the seed.
+func (service *crawlJobService) readSitemaps(ctx context.Context, job schemas.CrawlJob) []frontierItem {
+	locations := SitemapLocations(ctx, service.robots, job.SeedUrl)
+	urls, _ := service.sitemaps.Read(ctx, locations, job.Settings.MaxPages)
+
+	normalizer := urlNormalizer(job.Settings)
+	scope, _ := NewScope(job.Settings.Scope)
+	records := make([]schemas.CrawlSitemapUrl, 0, len(urls))
+	items := make([]frontierItem, 0, len(urls))
+	for _, sitemapUrl := range urls {
+		if normalized, err := normalizer.Normalize(scope.Rewrite(sitemapUrl.Url)); err == nil {
+			sitemapUrl.Url = normalized
+		}
+		records = append(records, schemas.CrawlSitemapUrl{
+			CrawlJobId: job.Id,
+			Url:        sitemapUrl.Url,
+			Sitemap:    sitemapUrl.Sitemap,
+		})
+		items = append(items, frontierItem{Url: sitemapUrl.Url, Depth: 1})
+	}
+	service.sitemapRepository.SaveAll(records)
+	return items
+}
+
+func (service *crawlJobService) saveFrontier(id uint64, state CrawlState) {
+	items := make([]schemas.CrawlFrontierItem, 0, len(state.Pending)+len(state.Visited))
+	for _, item := range state.Pending {
+		items = append(items, schemas.CrawlFrontierItem{CrawlJobId: id, Url: item.Url, Depth: item.Depth, Leaf: item.Leaf})
+	}
+	for _, url := range state.Visited {
+		items = append(items, schemas.CrawlFrontierItem{CrawlJobId: id, Url: url, Fetched: true})
+	}
+	service.frontierRepository.SaveAll(items)
+}
+
+// onProjectSite tells whether the seed of job and the hosts it may crawl
+// all belong to the site of project.
+func onProjectSite(project schemas.Project, job schemas.CrawlJob) bool {
+	hosts := seedHosts(project.Url)
+	seed := seedHosts(job.SeedUrl)
+	if len(hosts) == 0 || len(seed) == 0 || !slices.Contains(hosts, seed[0]) {
+		return false
+	}
+	for _, host := range job.Settings.Scope.AllowedHosts {
+		if !slices.Contains(hosts, strings.ToLower(strings.TrimSpace(host))) {
+			return false
+		}
+	}
+	return true
+}
+
+// seedHosts returns the hosts a crawl stays on unless told otherwise, the one
+// of its seed with and without www.
+func seedHosts(seedUrl string) []string {
+	seed, err := url.Parse(seedUrl)
+	if err != nil || seed.Hostname() == "" {
+		return nil
+	}
+	host := strings.ToLower(seed.Hostname())
+	if domain, found := strings.CutPrefix(host, "www."); found {
+		return []string{host, domain}
+	}
+	return []string{host, "www." + host}
+}
+
+// urlNormalizer returns the normalizer of the URLs of a crawl.
+func urlNormalizer(settings schemas.CrawlSettings) tools.UrlNormalizer {
+	switch {
+	case settings.KeepTrackingParams:
+		return tools.UrlNormalizer{}
+	case len(settings.TrackingParams) > 0:
+		return tools.UrlNormalizer{TrackingParams: settings.TrackingParams}
+	default:
+		return tools.UrlNormalizer{TrackingParams: tools.DefaultTrackingParams}
+	}
+}
+
+// mergeCrawlSettings returns base with the fields set in override replaced.
+func mergeCrawlSettings(base schemas.CrawlSettings, override schemas.CrawlSettings) schemas.CrawlSettings {
+	merged := reflect.ValueOf(&base).Elem()
+	overridden := reflect.ValueOf(override)
+	for i := 0; i < overridden.NumField(); i++ {
+		if !overridden.Field(i).IsZero() {
+			merged.Field(i).Set(overridden.Field(i))
+		}
+	}
+	return base
+}
+
+// finish sets the final status of a job unless it was already finished,
+// only writing its own columns so that the counters and the traps written
+// meanwhile by the workers are kept.
+func (service *crawlJobService) finish(id uint64, status string, err error) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	service.repository.Finish(id, status, time.Now(), message)
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/tools"
+)
+
+// maxLinkResponseLength is the size of the response column of schemas.Link.
+const maxLinkResponseLength = 100
+
+// crawlRecorder stores the result of every page checked by a running crawl
+// job along with the links found on it, and keeps the job counters up to date.
+type crawlRecorder struct {
+	mu              sync.Mutex
+	jobId           uint64
+	seedUrl         string
+	repository      repository.CrawlJobRepository
+	linkRepository  repository.LinkRepository
+	edgeRepository  repository.CrawlEdgeRepository
+	eventRepository repository.CrawlEventRepository
+	linkStates      LinkStateService
+	statePolicy     LinkStatePolicy
+	progress        schemas.CrawlProgress
+	seedErr         error
+	// The job is crawled by several workers, which add to its counters.
+	shared bool
+}
+
+func newCrawlRecorder(
+	job schemas.CrawlJob,
+	crawlJobRepository repository.CrawlJobRepository,
+	linkRepository repository.LinkRepository,
+	crawlEdgeRepository repository.CrawlEdgeRepository,
+	crawlEventRepository repository.CrawlEventRepository,
+	linkStateService LinkStateService,
+) *crawlRecorder {
+	return &crawlRecorder{
+		jobId:           job.Id,
+		seedUrl:         tools.NormalizeUrl(job.SeedUrl),
+		repository:      crawlJobRepository,
+		linkRepository:  linkRepository,
+		edgeRepository:  crawlEdgeRepository,
+		eventRepository: crawlEventRepository,
+		linkStates:      linkStateService,
+		statePolicy: LinkStatePolicy{
+			FailuresToBreak:    job.Settings.BrokenAfterFailures,
+			FailureWindow:      time.Duration(job.Settings.BrokenAfterSeconds) * time.Second,
+			SuccessesToRecover: job.Settings.RecoveredAfterSuccesses,
+		},
+		progress: job.CrawlProgress,
+	}
+}
+
+func (recorder *crawlRecorder) PageFetched(page Page, err error) {
+	link := schemas.Link{
+		LinkId:        recorder.linkRepository.FindOrCreateUrl(page.Url).Id,
+		StatusCode:    uint64(page.StatusCode),
+		Response:      http.StatusText(page.StatusCode),
+		Ping:          uint64(page.Duration.Milliseconds()),
+		RedirectUrl:   page.FinalUrl,
+		Canonical:     page.Canonical,
+		Anchors:       page.Anchors,
+		ETag:          page.ETag,
+		LastModified:  page.LastModified,
+		ContentHash:   page.ContentHash,
+		Unchanged:     page.Unchanged,
+		Method:        page.Method,
+		ErrorClass:    ClassifyError(page.StatusCode, err),
+		Attempts:      page.Attempts,
+		RedirectCount: len(page.Redirects),
+		CrawlJobId:    recorder.jobId,
+	}
+	for _, hop := range page.Redirects {
+		link.Redirects = append(link.Redirects, schemas.RedirectHop{
+			Url:        hop.Url,
+			StatusCode: hop.StatusCode,
+			Latency:    uint64(hop.Duration.Milliseconds()),
+		})
+	}
+	skipped := errors.Is(err, ErrSkippedByRobots)
+	if err != nil && !skipped {
+		// A failure only makes the URL broken once confirmed by its state.
+		state := recorder.linkStates.Record(link.LinkId, true, recorder.statePolicy)
+		link.Broken = state.State == schemas.LinkStateBroken
+		link.Suspect = !link.Broken
+	} else if !skipped {
+		recorder.linkStates.Record(link.LinkId, false, recorder.statePolicy)
+	}
+	if err != nil {
+		link.Response = err.Error()
+	}
+	if response := []rune(link.Response); len(response) > maxLinkResponseLength {
+		link.Response = string(response[:maxLinkResponseLength])
+	}
+	recorder.linkRepository.Save(link)
+
+	edges := make([]schemas.CrawlEdge, 0, len(page.Links))
+	for _, pageLink := range page.Links {
+		edges = append(edges, schemas.CrawlEdge{
+			CrawlJobId: recorder.jobId,
+			SourceUrl:  page.Url,
+			TargetUrl:  pageLink.Url,
+			AnchorText: pageLink.AnchorText,
+			Tag:        pageLink.Tag,
+			Attribute:  pageLink.Attribute,
+			Rel:        pageLink.Rel,
+			Kind:       pageLink.Kind,
+			NoFollow:   pageLink.NoFollow,
+			Fragment:   pageLink.Fragment,
+		})
+	}
+	recorder.edgeRepository.SaveAll(edges)
+
+	event := schemas.CrawlEvent{
+		CrawlJobId: recorder.jobId,
+		Type:       schemas.CrawlEventPage,
+		Page: schemas.CrawlPageEvent{
+			Url:        page.Url,
+			Method:     page.Method,
+			StatusCode: page.StatusCode,
+			Response:   link.Response,
+			ErrorClass: link.ErrorClass,
+			Broken:     link.Broken,
+			Suspect:    link.Suspect,
+			Skipped:    skipped,
+			Unchanged:  page.Unchanged,
+			Ping:       link.Ping,
+			Links:      len(page.Links),
+		},
+	}
+	events := []schemas.CrawlEvent{event}
+	switch {
+	case link.Broken:
+		event.Type = schemas.CrawlEventBrokenLink
+		events = append(events, event)
+	case link.Suspect:
+		event.Type = schemas.CrawlEventSuspectLink
+		events = append(events, event)
+	}
+	recorder.eventRepository.SaveAll(events)
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	delta := schemas.CrawlProgress{}
+	switch {
+	case skipped:
+		delta.PagesSkipped++
+	case err != nil:
+		delta.PagesFetched++
+		if link.Broken {
+			delta.BrokenLinks++
+		} else {
+			delta.SuspectLinks++
+		}
+		if page.Url == recorder.seedUrl {
+			recorder.seedErr = err
+		}
+	case page.Unchanged:
+		delta.PagesFetched++
+		delta.PagesUnchanged++
+	default:
+		delta.PagesFetched++
+	}
+	recorder.progress.PagesFetched += delta.PagesFetched
+	recorder.progress.BrokenLinks += delta.BrokenLinks
+	recorder.progress.SuspectLinks += delta.SuspectLinks
+	recorder.progress.PagesSkipped += delta.PagesSkipped
+	recorder.progress.PagesUnchanged += delta.PagesUnchanged
+	if recorder.shared {
+		recorder.repository.AddProgress(recorder.jobId, delta)
+	} else {
+		recorder.repository.UpdateProgress(recorder.jobId, recorder.progress)
+	}
+}
+
+// seedError returns the error the seed of the crawl was fetched with.
+func (recorder *crawlRecorder) seedError() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return recorder.seedErr
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+const (
+	// crawlWorkerLease is how long an item stays leased to a worker which
+	// stopped renewing it, before the other workers reclaim it.
+	crawlWorkerLease     = 2 * time.Minute
+	crawlWorkerHeartbeat = 30 * time.Second
+	crawlWorkerPoll      = 2 * time.Second
+	// crawlWorkerJobTTL is how long a worker caches a job before checking
+	// whether it is still running.
+	crawlWorkerJobTTL = time.Minute
+	// crawlWorkerHostIdle is how long the schedule of a host no longer
+	// requested is kept.
+	crawlWorkerHostIdle = time.Hour
+)
+
+// CrawlWorker fetches the URLs of the crawls queued in the database, along
+// with the other workers. The intervals between the requests to a host and
+// its backoffs are shared with them, its concurrency limit being per worker.
+// So are the trap thresholds, each worker only counting the URLs it queued:
+// a crawl run by n workers may follow up to n times more URLs of a pattern
+// before it is reported as a trap.
+type CrawlWorker struct {
+	id              string
+	concurrency     int
+	workRepository  repository.CrawlWorkRepository
+	hostRepository  repository.CrawlHostRepository
+	repository      repository.CrawlJobRepository
+	linkRepository  repository.LinkRepository
+	edgeRepository  repository.CrawlEdgeRepository
+	eventRepository repository.CrawlEventRepository
+	linkStates      LinkStateService
+	crawlResources
+
+	mu     sync.Mutex
+	crawls map[uint64]*workerCrawl
+}
+
+// workerCrawl is a job a worker fetches the URLs of. It is replaced rather
+// than changed when the job is loaded again, being used by every goroutine
+// fetching its URLs.
+type workerCrawl struct {
+	job      schemas.CrawlJob
+	crawler  *Crawler
+	recorder *crawlRecorder
+	loadedAt time.Time
+	// mu serializes the use of the trap detector of the crawler.
+	mu *sync.Mutex
+}
+
+// NewCrawlWorker returns a worker fetching up to concurrency URLs at once,
+// defaultCrawlWorkers when 0.
+func NewCrawlWorker(
+	crawlWorkRepository repository.CrawlWorkRepository,
+	crawlHostRepository repository.CrawlHostRepository,
+	crawlJobRepository repository.CrawlJobRepository,
+	linkRepository repository.LinkRepository,
+	crawlEdgeRepository repository.CrawlEdgeRepository,
+	crawlEventRepository repository.CrawlEventRepository,
+	linkStateService LinkStateService,
+	fetcher Fetcher,
+	concurrency int,
+) *CrawlWorker {
+	if concurrency <= 0 {
+		concurrency = defaultCrawlWorkers
+	}
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	resources := newCrawlResources(fetcher)
+	resources.politeness = NewSharedPoliteness(crawlHostRepository)
+	return &CrawlWorker{
+		id:              fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix)),
+		concurrency:     concurrency,
+		workRepository:  crawlWorkRepository,
+		hostRepository:  crawlHostRepository,
+		repository:      crawlJobRepository,
+		linkRepository:  linkRepository,
+		edgeRepository:  crawlEdgeRepository,
+		eventRepository: crawlEventRepository,
+		linkStates:      linkStateService,
+		crawlResources:  resources,
+		crawls:          make(map[uint64]*workerCrawl),
+	}
+}
+
+// Run fetches the URLs leased to the worker until ctx is done, then hands
+// back the ones it did not fetch.
+func (worker *CrawlWorker) Run(ctx context.Context) {
+	log.Println("crawl worker", worker.id, "started")
+	heartbeat := time.NewTicker(crawlWorkerHeartbeat)
+	defer heartbeat.Stop()
+	poll := time.NewTimer(0)
+	defer poll.Stop()
+
+	slots := make(chan struct{}, worker.concurrency)
+	var wg sync.WaitGroup
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			worker.workRepository.ReleaseWorker(worker.id)
+			log.Println("crawl worker", worker.id, "stopped")
+			return
+		case <-heartbeat.C:
+			worker.workRepository.Extend(worker.id, crawlWorkerLease)
+			worker.reapTimedOut()
+			worker.hostRepository.DeleteIdle(crawlWorkerHostIdle)
+		case <-poll.C:
+			free := worker.concurrency - len(slots)
+			var items []schemas.CrawlWorkItem
+			if free > 0 {
+				items = worker.workRepository.Claim(worker.id, free, crawlWorkerLease)
+			}
+			for _, item := range items {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(item schemas.CrawlWorkItem) {
+					defer func() {
+						if r := recover(); r != nil {
+							log.Println("crawl worker", worker.id, "failed to fetch", item.Url, r)
+						}
+						<-slots
+						wg.Done()
+					}()
+					worker.process(ctx, item)
+				}(item)
+			}
+			// Claim again right away while there is more work than slots.
+			if free > 0 && len(items) == free {
+				poll.Reset(0)
+			} else {
+				poll.Reset(crawlWorkerPoll)
+			}
+		}
+	}
+}
+
+// process fetches the URL of an item, queues the links found on it and
+// finishes its crawl once no item is left.
+func (worker *CrawlWorker) process(ctx context.Context, item schemas.CrawlWorkItem) {
+	crawl, err := worker.crawl(item.CrawlJobId)
+	if err != nil {
+		worker.workRepository.DeleteByCrawlJob(item.CrawlJobId)
+		return
+	}
+	if crawlTimedOut(crawl.job) {
+		worker.finish(crawl.job.Id, schemas.CrawlJobFailed, errCrawlTimedOut)
+		return
+	}
+
+	result := crawl.crawler.fetch(ctx, frontierItem{
+		Url:      item.Url,
+		Depth:    item.Depth,
+		Attempts: item.Attempts,
+		Leaf:     item.Leaf,
+	})
+	switch {
+	case result.interrupted:
+		worker.workRepository.Release(item.Id, item.Attempts)
+		return
+	case result.throttled:
+		worker.workRepository.Release(item.Id, item.Attempts+1)
+		return
+	}
+	worker.queue(crawl, result)
+	worker.workRepository.Complete(item.Id)
+	if seedErr := crawl.recorder.seedError(); seedErr != nil && item.Depth == 0 {
+		worker.repository.UpdateError(crawl.job.Id, seedErr.Error())
+	}
+
+	if worker.workRepository.Remaining(crawl.job.Id) == 0 {
+		worker.finish(crawl.job.Id, schemas.CrawlJobSucceeded, nil)
+	}
+}
+
+// reapTimedOut fails the running crawls past their timeout, including the
+// stalled ones no item of which is left to process.
+func (worker *CrawlWorker) reapTimedOut() {
+	for _, job := range worker.repository.FindByStatus(schemas.CrawlJobRunning) {
+		if crawlTimedOut(job) {
+			worker.finish(job.Id, schemas.CrawlJobFailed, errCrawlTimedOut)
+		}
+	}
+}
+
+// crawlTimedOut tells whether a job has been running for longer than its timeout.
+func crawlTimedOut(job schemas.CrawlJob) bool {
+	timeout := time.Duration(job.Settings.Timeout) * time.Second
+	return timeout > 0 && job.StartedAt != nil && time.Since(*job.StartedAt) > timeout
+}
+
+// queue queues the links of a fetched page which were not queued yet, and
+// records the traps they fell in.
+func (worker *CrawlWorker) queue(crawl *workerCrawl, result crawlResult) {
+	followed := crawl.crawler.followed(result)
+	if len(followed) == 0 {
+		return
+	}
+	hashes := make([]int64, 0, len(followed))
+	for i := range followed {
+		followed[i].Url = crawl.crawler.normalize(followed[i].Url)
+		hashes = append(hashes, int64(urlHash(followed[i].Url)))
+	}
+	// Only the new URLs count towards the traps.
+	existing := worker.workRepository.FindExisting(crawl.job.Id, hashes)
+
+	crawl.mu.Lock()
+	items := make([]schemas.CrawlWorkItem, 0, len(followed))
+	for i, item := range followed {
+		if existing[hashes[i]] || !crawl.crawler.admit(&item) {
+			continue
+		}
+		existing[hashes[i]] = true
+		items = append(items, newCrawlWorkItem(crawl.job.Id, item))
+	}
+	traps := crawl.crawler.traps.Drain()
+	crawl.mu.Unlock()
+
+	worker.workRepository.Push(items, crawl.job.Settings.MaxPages)
+	if len(traps) > 0 {
+		worker.repository.UpdateTraps(crawl.job.Id, func(known []schemas.CrawlTrap) []schemas.CrawlTrap {
+			return mergeTraps(known, traps)
+		})
+	}
+}
+
+// crawl returns the crawl of a job, loading it again once in a while to
+// forget about it when it is no longer running.
+func (worker *CrawlWorker) crawl(jobId uint64) (*workerCrawl, error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	crawl := worker.crawls[jobId]
+	if crawl != nil && time.Since(crawl.loadedAt) < crawlWorkerJobTTL {
+		return crawl, nil
+	}
+	job, err := worker.repository.FindById(jobId)
+	if errors.Is(err, repository.ErrNotFound) {
+		delete(worker.crawls, jobId)
+		return nil, ErrCrawlJobNotFound
+	}
+	if job.Status != schemas.CrawlJobRunning {
+		// Fetched anyway, the lease being taken while it was running
+		delete(worker.crawls, jobId)
+	}
+	if crawl != nil {
+		crawl = &workerCrawl{
+			job:      job,
+			crawler:  crawl.crawler,
+			recorder: crawl.recorder,
+			loadedAt: time.Now(),
+			mu:       crawl.mu,
+		}
+		if job.Status == schemas.CrawlJobRunning {
+			worker.crawls[jobId] = crawl
+		}
+		return crawl, nil
+	}
+
+	recorder := newCrawlRecorder(job, worker.repository, worker.linkRepository, worker.edgeRepository, worker.eventRepository, worker.linkStates)
+	recorder.shared = true
+	crawl = &workerCrawl{
+		job:      job,
+		crawler:  worker.crawler(job, nil, pageHistory(job, worker.linkRepository, worker.edgeRepository), recorder),
+		recorder: recorder,
+		loadedAt: time.Now(),
+		mu:       &sync.Mutex{},
+	}
+	if job.Status == schemas.CrawlJobRunning {
+		worker.crawls[jobId] = crawl
+	}
+	return crawl, nil
+}
+
+// finish ends a crawl run by the workers unless it was already, failing it
+// when its seed could not be fetched.
+func (worker *CrawlWorker) finish(jobId uint64, status string, err error) {
+	if job, findErr := worker.repository.FindById(jobId); err == nil && findErr == nil && job.Error != "" {
+		status = schemas.CrawlJobFailed
+	}
+	if !worker.repository.FinishRunning(jobId, status, time.Now()) {
+		return
+	}
+	if err != nil {
+		worker.repository.UpdateError(jobId, err.Error())
+	}
+	worker.workRepository.DeleteByCrawlJob(jobId)
+	log.Println("crawl job", jobId, status)
+}
+
+func newCrawlWorkItem(jobId uint64, item frontierItem) schemas.CrawlWorkItem {
+	return schemas.CrawlWorkItem{
+		CrawlJobId: jobId,
+		Hash:       int64(urlHash(item.Url)),
+		Url:        item.Url,
+		Depth:      item.Depth,
+		Leaf:       item.Leaf,
+		Attempts:   item.Attempts,
+		Status:     schemas.CrawlWorkPending,
+	}
+}
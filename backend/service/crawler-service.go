@@ -1,41 +1,469 @@
 package service
 
 import (
-	"fmt"
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+	"github.com/Tom-Mendy/SentryLink/tools"
+)
+
+const (
+	defaultCrawlWorkers = 8
+	// maxThrottledAttempts bounds the fetches of a page answered 429 or 503.
+	maxThrottledAttempts = 3
 )
 
+// CrawlObserver is notified of every page visited by a crawl.
+type CrawlObserver interface {
+	PageFetched(page Page, err error)
+}
+
+// CrawlObserverFunc adapts a function to the CrawlObserver interface.
+type CrawlObserverFunc func(page Page, err error)
+
+func (f CrawlObserverFunc) PageFetched(page Page, err error) {
+	f(page, err)
+}
+
+// CrawlOptions are the limits enforced by a Crawler.
+type CrawlOptions struct {
+	Workers         int           // Pages fetched concurrently
+	MaxDepth        int           // Links followed from the seeds, 0 only fetching the seeds
+	MaxPages        int           // Page budget of the crawl, 0 meaning no limit
+	RequestTimeout  time.Duration // Deadline of every fetch, 0 meaning none
+	Robots          *RobotsCache  // Rules consulted before every fetch, nil ignoring robots.txt
+	Politeness      *Politeness   // Limiter of the requests made to every host, nil for none
+	HostPolicy      HostPolicy    // Limits applied by Politeness, Crawl-delay being added by Robots
+	ResourceKinds   []string      // Kinds of the links checked, all of them when empty
+	NoFollow        string        // Policy for the nofollow links, schemas.NoFollowCheck when empty
+	Normalizer      tools.UrlNormalizer
+	Soft404         *Soft404Detector // Detector of the not found pages answered 200, nil for none
+	Soft404Patterns Soft404Patterns
+	AlwaysGet       bool        // Downloads the targets which are not crawled instead of checking them with HEAD
+	MaxBodySize     int64       // Bytes downloaded of every page, defaultMaxBodySize when 0
+	Retry           RetryPolicy // Of the fetches failing with a transient error
+	Scope           *Scope      // URLs fetched besides the seeds, nil for all of them
+	Traps           TrapPolicy  // Of the URLs not followed, none of them when zero
+	// Exact set of the visited URLs behind a Bloom filter, nil keeping them
+	// all in memory. It is cleared when the crawl stops.
+	Visited        VisitedStore
+	FrontierMemory int // URLs of the frontier kept in memory, 0 meaning all of them
+	// Last copy of the pages, only downloaded again when they changed. nil
+	// downloads all of them.
+	History PageHistory
+}
+
+// CrawlState is what is left to do by an interrupted crawl, enough to resume
+// it later without fetching the visited pages again.
+type CrawlState struct {
+	Pending []frontierItem
+	Visited []string
+}
+
+// Crawler crawls a site with a fixed pool of workers fed from a frontier,
+// the depth and the page budget being enforced by a single dispatcher.
+type Crawler struct {
+	fetcher  Fetcher
+	options  CrawlOptions
+	observer CrawlObserver
+	traps    *trapDetector
+}
+
+type crawlResult struct {
+	item        frontierItem
+	page        Page
+	err         error
+	interrupted bool
+	throttled   bool
+}
+
+func NewCrawler(fetcher Fetcher, options CrawlOptions, observer CrawlObserver) *Crawler {
+	if options.Workers <= 0 {
+		options.Workers = defaultCrawlWorkers
+	}
+	return &Crawler{
+		fetcher:  fetcher,
+		options:  options,
+		observer: observer,
+		traps:    newTrapDetector(options.Traps),
+	}
+}
+
+// Run crawls from the seeds until the frontier is exhausted or the page
+// budget is spent. See Resume for the handling of ctx.
+func (crawler *Crawler) Run(ctx context.Context, seeds ...string) (CrawlState, error) {
+	state := CrawlState{}
+	for _, seed := range seeds {
+		state.Pending = append(state.Pending, frontierItem{Url: seed})
+	}
+	return crawler.Resume(ctx, state)
+}
+
+// Resume carries on a crawl from its state. When ctx is done the fetches in
+// progress are abandoned and the state left is returned along with the cause
+// of the interruption.
+func (crawler *Crawler) Resume(ctx context.Context, state CrawlState) (CrawlState, error) {
+	queue := newFrontier(crawler.options.FrontierMemory)
+	defer queue.Close()
+	crawler.traps = newTrapDetector(crawler.options.Traps)
+	var visited visitedSet = memoryVisitedSet{}
+	if store := crawler.options.Visited; store != nil {
+		store.Clear()
+		defer store.Clear()
+		visited = newBloomVisitedSet(store, max(crawler.options.MaxPages, len(state.Visited)))
+	}
+	for _, url := range state.Visited {
+		visited.Add(url)
+	}
+	enqueue := func(item frontierItem) {
+		item.Url = crawler.normalize(item.Url)
+		if visited.Contains(item.Url) || !crawler.admit(&item) {
+			return
+		}
+		if crawler.options.MaxPages > 0 && visited.Len() >= crawler.options.MaxPages {
+			return
+		}
+		visited.Add(item.Url)
+		queue.Push(item)
+	}
+	for _, item := range state.Pending {
+		enqueue(item)
+	}
+
+	items := make(chan frontierItem)
+	results := make(chan crawlResult)
+	var wg sync.WaitGroup
+	for i := 0; i < crawler.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				results <- crawler.fetch(ctx, item)
+			}
+		}()
+	}
+
+	done := ctx.Done()
+	interrupted := false
+	inFlight := 0
+	for (queue.Len() > 0 && !interrupted) || inFlight > 0 {
+		// Only offer work when there is some, a nil channel blocks forever.
+		var next chan frontierItem
+		var item frontierItem
+		if queue.Len() > 0 && !interrupted {
+			next = items
+			item = queue.Peek()
+		}
+
+		select {
+		case <-done:
+			interrupted = true
+			done = nil
+		case next <- item:
+			queue.Pop()
+			inFlight++
+		case result := <-results:
+			inFlight--
+			if result.interrupted {
+				queue.Push(result.item)
+				continue
+			}
+			if result.throttled {
+				// Fetched again once the host backoff is over
+				result.item.Attempts++
+				queue.Push(result.item)
+				continue
+			}
+			for _, item := range crawler.followed(result) {
+				enqueue(item)
+			}
+		}
+	}
+	close(items)
+	wg.Wait()
+
+	if !interrupted {
+		return CrawlState{}, nil
+	}
+	left := CrawlState{}
+	pending := make(map[string]bool, queue.Len())
+	for queue.Len() > 0 {
+		item := queue.Pop()
+		pending[item.Url] = true
+		left.Pending = append(left.Pending, item)
+	}
+	visited.Each(func(url string) {
+		if !pending[url] {
+			left.Visited = append(left.Visited, url)
+		}
+	})
+	return left, context.Cause(ctx)
+}
+
+// admit tells whether a URL found by the crawl, which is not a seed, is in
+// its scope and not in a crawler trap, only checking it if it is external.
+func (crawler *Crawler) admit(item *frontierItem) bool {
+	if item.Depth == 0 {
+		return true
+	}
+	switch crawler.options.Scope.check(item.Url) {
+	case scopeExcluded:
+		return false
+	case scopeExternal:
+		item.Leaf = true
+	}
+	return crawler.traps.Allow(item.Url)
+}
+
+// followed returns the links of a fetched page to queue, none when the page
+// is not crawled further.
+func (crawler *Crawler) followed(result crawlResult) []frontierItem {
+	if result.err != nil || result.item.Leaf || result.item.Depth >= crawler.options.MaxDepth {
+		return nil
+	}
+	items := make([]frontierItem, 0, len(result.page.Links))
+	for _, link := range result.page.Links {
+		depth := result.item.Depth + 1
+		if link.NoFollow && crawler.options.NoFollow != schemas.NoFollowFollow {
+			// Checked, but at the maximum depth so that it is not crawled further
+			depth = max(depth, crawler.options.MaxDepth)
+		}
+		items = append(items, frontierItem{
+			Url:   link.Url,
+			Depth: depth,
+			Leaf:  leafResource(link.Kind) || (depth >= crawler.options.MaxDepth && external(result.page, link.Url)),
+		})
+	}
+	return items
+}
+
+// Traps returns the crawler traps detected by the last run of the crawl.
+func (crawler *Crawler) Traps() []schemas.CrawlTrap {
+	return crawler.traps.Traps()
+}
+
+// fetch fetches the page of item if robots.txt allows it, and reports it
+// unless the fetch was abandoned because the crawl is being interrupted or
+// will be retried because the host is overloaded.
+func (crawler *Crawler) fetch(ctx context.Context, item frontierItem) crawlResult {
+	policy := crawler.options.HostPolicy
+	if robots := crawler.options.Robots; robots != nil {
+		if !robots.Allowed(ctx, item.Url) {
+			page := Page{Url: item.Url}
+			crawler.observer.PageFetched(page, ErrSkippedByRobots)
+			return crawlResult{item: item, page: page, err: ErrSkippedByRobots}
+		}
+		policy.MinInterval = robots.CrawlDelay(ctx, item.Url)
+	}
+
+	var previous PreviousPage
+	known := false
+	if crawler.options.History != nil && !item.Leaf {
+		previous, known = crawler.options.History.Previous(item.Url)
+	}
+
+	var page Page
+	var err error
+	for attempt := 1; ; attempt++ {
+		page, err = crawler.attempt(ctx, item, policy, previous)
+		if err != nil && ctx.Err() != nil {
+			return crawlResult{item: item, interrupted: true}
+		}
+		overloaded := hostOverloaded(page.StatusCode)
+		if crawler.options.Politeness != nil && overloaded && item.Attempts+1 < maxThrottledAttempts {
+			return crawlResult{item: item, throttled: true}
+		}
+		page.Attempts = item.Attempts + attempt
+		// An overloaded host was already given time by the politeness backoff.
+		if err == nil || overloaded || attempt >= crawler.options.Retry.MaxAttempts || !transientError(ClassifyError(page.StatusCode, err)) {
+			break
+		}
+		if !sleep(ctx, crawler.options.Retry.Delay(attempt)) {
+			return crawlResult{item: item, interrupted: true}
+		}
+	}
+
+	if err == nil && known && page.StatusCode == http.StatusNotModified {
+		crawler.unchanged(&page, previous)
+	}
+	// The previous copy of an unchanged page was not a soft 404.
+	if err == nil && !page.Unchanged && crawler.options.Soft404 != nil && crawler.options.Soft404.IsSoft404(ctx, page, crawler.options.Soft404Patterns) {
+		err = ErrSoft404
+	}
+	page.Links = crawler.wantedLinks(page.Links)
+	crawler.observer.PageFetched(page, err)
+	return crawlResult{item: item, page: page, err: err}
+}
+
+// unchanged fills a page answered 304 with what its previous copy held, its
+// status included so that the reports see it as it was.
+func (crawler *Crawler) unchanged(page *Page, previous PreviousPage) {
+	page.Unchanged = true
+	page.StatusCode = previous.StatusCode
+	page.Links = crawler.options.History.Links(previous)
+	page.PageMeta = previous.PageMeta
+	page.ContentHash = previous.ContentHash
+	// Servers may leave out of a 304 the validators which did not change.
+	if page.ETag == "" {
+		page.ETag = previous.ETag
+	}
+	if page.LastModified == "" {
+		page.LastModified = previous.LastModified
+	}
+}
+
+// attempt fetches the page of item once, within the limits of its host, only
+// downloading it again if it changed since previous.
+func (crawler *Crawler) attempt(ctx context.Context, item frontierItem, policy HostPolicy, previous PreviousPage) (Page, error) {
+	politeness, host := crawler.options.Politeness, ""
+	if politeness != nil {
+		if target, err := url.Parse(item.Url); err == nil {
+			host = target.Host
+		}
+		if err := politeness.Acquire(ctx, host, policy); err != nil {
+			return Page{Url: item.Url}, err
+		}
+	}
+
+	fetchCtx, cancel := ctx, context.CancelFunc(func() {})
+	if crawler.options.RequestTimeout > 0 {
+		fetchCtx, cancel = context.WithTimeout(ctx, crawler.options.RequestTimeout)
+	}
+	request := FetchRequest{Url: item.Url, Method: MethodGet, MaxBodySize: crawler.options.MaxBodySize}
+	if item.Leaf && !crawler.options.AlwaysGet {
+		request.Method = MethodHead
+	} else {
+		request.ETag, request.LastModified = previous.ETag, previous.LastModified
+	}
+	page, err := crawler.fetcher.Fetch(fetchCtx, request)
+	cancel()
+
+	if politeness != nil {
+		politeness.Release(host, page.StatusCode, retryAfter(page.Header))
+	}
+	return page, err
+}
+
+// leafResource tells whether a kind of resource never links to other ones.
+func leafResource(kind string) bool {
+	switch kind {
+	case schemas.ResourceImage, schemas.ResourceScript, schemas.ResourceMedia, schemas.ResourceCss:
+		return true
+	}
+	return false
+}
+
+// external tells whether a link leads to another host than the one of the
+// page it was found on, once redirected.
+func external(page Page, link string) bool {
+	pageURL := page.Url
+	if page.FinalUrl != "" {
+		pageURL = page.FinalUrl
+	}
+	source, err := url.Parse(pageURL)
+	if err != nil {
+		return false
+	}
+	target, err := url.Parse(link)
+	return err == nil && !strings.EqualFold(source.Host, target.Host)
+}
+
+// normalize returns the normalized form of a URL, the one the visited set
+// and the results are keyed on.
+func (crawler *Crawler) normalize(rawURL string) string {
+	rawURL = crawler.options.Scope.Rewrite(rawURL)
+	normalized, err := crawler.options.Normalizer.Normalize(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return normalized
+}
+
+// wantedLinks normalizes the links, filtering out the ones out of the scope,
+// to the kinds of resources not checked and, when they are ignored, the
+// nofollow links.
+func (crawler *Crawler) wantedLinks(links []PageLink) []PageLink {
+	allKinds := len(crawler.options.ResourceKinds) == 0
+	ignoreNoFollow := crawler.options.NoFollow == schemas.NoFollowIgnore
+	wanted := links[:0]
+	for _, link := range links {
+		if i := strings.IndexByte(link.Url, '#'); i >= 0 {
+			link.Fragment = link.Url[i+1:]
+			if fragment, err := url.PathUnescape(link.Fragment); err == nil {
+				link.Fragment = fragment
+			}
+		}
+		link.Url = crawler.normalize(link.Url)
+		if !allKinds && !slices.Contains(crawler.options.ResourceKinds, link.Kind) {
+			continue
+		}
+		if ignoreNoFollow && link.NoFollow {
+			continue
+		}
+		if crawler.options.Scope.check(link.Url) == scopeExcluded {
+			continue
+		}
+		wanted = append(wanted, link)
+	}
+	return wanted
+}
+
 type UrlsFetched struct {
 	mu      sync.Mutex
 	fetched map[string]bool
+	limit   int
 }
 
-func Crawl(url string, depth int, fetcher Fetcher, urlsFetched *UrlsFetched) {
-	if depth <= 0 {
+// NewUrlsFetched returns an empty visited set allowing at most limit pages
+// to be fetched, 0 meaning no limit.
+func NewUrlsFetched(limit int) *UrlsFetched {
+	return &UrlsFetched{
+		fetched: make(map[string]bool),
+		limit:   limit,
+	}
+}
+
+// Crawl uses fetcher to recursively crawl
+// pages starting with url, to a maximum of depth.
+//
+// It starts a goroutine per link found and is only kept as a baseline for
+// Crawler, which bounds the number of concurrent fetches.
+func Crawl(ctx context.Context, url string, depth int, fetcher Fetcher, urlsFetched *UrlsFetched, observer CrawlObserver) {
+	if depth <= 0 || ctx.Err() != nil {
 		return
 	}
+	url = tools.NormalizeUrl(url)
 
 	urlsFetched.mu.Lock()
 	if urlsFetched.fetched[url] {
 		urlsFetched.mu.Unlock()
 		return
 	}
+	if urlsFetched.limit > 0 && len(urlsFetched.fetched) >= urlsFetched.limit {
+		urlsFetched.mu.Unlock()
+		return
+	}
 	urlsFetched.fetched[url] = true
 	urlsFetched.mu.Unlock()
 
-	body, urls, err := fetcher.Fetch(url)
+	page, err := fetcher.Fetch(ctx, NewFetchRequest(url))
+	observer.PageFetched(page, err)
 	if err != nil {
-		fmt.Println(err)
 		return
 	}
-	fmt.Printf("found: %s %q\n", url, body)
 
 	var wg sync.WaitGroup
-	for _, u := range urls {
+	for _, u := range page.Urls() {
 		wg.Add(1)
 		go func(u string) {
 			defer wg.Done()
-			Crawl(u, depth-1, fetcher, urlsFetched)
+			Crawl(ctx, u, depth-1, fetcher, urlsFetched, observer)
 		}(u)
 	}
 	wg.Wait()
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+// The synthetic site both crawlers are benchmarked on: every page links to
+// the first one and to fanout children, each fetch taking latency.
+//
+// Crawl starts a goroutine for every link it finds, so it has as many
+// fetches in flight as the CPU lets it start, thousands of them. Crawler
+// never has more than Workers: with 64 of them it cannot take less than
+// nodes/64 × latency, about 1.6s, whereas with 1024 it keeps up with Crawl.
+// It also normalizes every URL and checks it against the traps, which Crawl
+// does not, hence its extra allocations. The benchmarks report the most
+// fetches in flight.
+const (
+	syntheticSiteUrl = "https://synthetic.test/page/"
+	syntheticNodes   = 100000
+	syntheticFanout  = 10
+	syntheticLatency = time.Millisecond
+	// Levels of links below the first page, enough to reach every node
+	syntheticDepth = 5
+)
+
+// syntheticFetcher serves a generated site without any network access.
+type syntheticFetcher struct {
+	nodes       int
+	fanout      int
+	latency     time.Duration
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+}
+
+func newSyntheticFetcher(nodes int, fanout int, latency time.Duration) *syntheticFetcher {
+	return &syntheticFetcher{
+		nodes:   nodes,
+		fanout:  fanout,
+		latency: latency,
+	}
+}
+
+func syntheticPageUrl(i int) string {
+	return syntheticSiteUrl + strconv.Itoa(i)
+}
+
+func (fetcher *syntheticFetcher) Fetch(ctx context.Context, request FetchRequest) (Page, error) {
+	url := request.Url
+	page := Page{Url: url, Method: MethodGet, Duration: fetcher.latency}
+	inFlight := fetcher.inFlight.Add(1)
+	defer fetcher.inFlight.Add(-1)
+	for peak := fetcher.maxInFlight.Load(); inFlight > peak && !fetcher.maxInFlight.CompareAndSwap(peak, inFlight); {
+		peak = fetcher.maxInFlight.Load()
+	}
+	if fetcher.latency > 0 {
+		timer := time.NewTimer(fetcher.latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return page, &FetchError{Url: url, Err: ctx.Err()}
+		case <-timer.C:
+		}
+	} else if ctx.Err() != nil {
+		return page, &FetchError{Url: url, Err: ctx.Err()}
+	}
+
+	i, err := strconv.Atoi(strings.TrimPrefix(url, syntheticSiteUrl))
+	if !strings.HasPrefix(url, syntheticSiteUrl) || err != nil || i < 0 || i >= fetcher.nodes {
+		page.StatusCode = http.StatusNotFound
+		return page, &FetchError{Url: url, StatusCode: http.StatusNotFound}
+	}
+
+	page.StatusCode = http.StatusOK
+	page.Body = fmt.Sprintf("Page %d", i)
+	page.Links = append(page.Links, PageLink{Url: syntheticPageUrl(0), Tag: "a", Attribute: "href", Kind: schemas.ResourceAnchor})
+	for child := i*fetcher.fanout + 1; child <= i*fetcher.fanout+fetcher.fanout && child < fetcher.nodes; child++ {
+		page.Links = append(page.Links, PageLink{Url: syntheticPageUrl(child), Tag: "a", Attribute: "href", Kind: schemas.ResourceAnchor})
+	}
+	return page, nil
+}
+
+func BenchmarkCrawler(b *testing.B) {
+	for _, workers := range []int{64, 1024} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			fetcher := newSyntheticFetcher(syntheticNodes, syntheticFanout, syntheticLatency)
+			for i := 0; i < b.N; i++ {
+				var fetched atomic.Int64
+				crawler := NewCrawler(fetcher, CrawlOptions{Workers: workers, MaxDepth: syntheticDepth}, CrawlObserverFunc(func(page Page, err error) {
+					fetched.Add(1)
+				}))
+				if _, err := crawler.Run(context.Background(), syntheticPageUrl(0)); err != nil {
+					b.Fatal(err)
+				}
+				if fetched.Load() != syntheticNodes {
+					b.Fatalf("fetched %d pages, want %d", fetched.Load(), syntheticNodes)
+				}
+			}
+			b.ReportMetric(float64(fetcher.maxInFlight.Load()), "max-in-flight")
+		})
+	}
+}
+
+func BenchmarkCrawl(b *testing.B) {
+	b.ReportAllocs()
+	fetcher := newSyntheticFetcher(syntheticNodes, syntheticFanout, syntheticLatency)
+	for i := 0; i < b.N; i++ {
+		var fetched atomic.Int64
+		Crawl(context.Background(), syntheticPageUrl(0), syntheticDepth+1, fetcher, NewUrlsFetched(0), CrawlObserverFunc(func(page Page, err error) {
+			fetched.Add(1)
+		}))
+		if fetched.Load() != syntheticNodes {
+			b.Fatalf("fetched %d pages, want %d", fetched.Load(), syntheticNodes)
+		}
+	}
+	b.ReportMetric(float64(fetcher.maxInFlight.Load()), "max-in-flight")
+}
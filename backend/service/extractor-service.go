@@ -0,0 +1,346 @@
+package service
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+// ElementExtractor returns the raw URLs an element references, each one
+// with the attribute it came from and its kind of resource.
+type ElementExtractor func(token html.Token) []PageLink
+
+// LinkExtractor finds the URLs referenced by HTML documents and stylesheets.
+// The elements it knows about are given by the ElementExtractors registered
+// for their tag.
+type LinkExtractor struct {
+	elements map[string][]ElementExtractor
+}
+
+var (
+	cssUrlPattern    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"]*))\s*\)`)
+	cssImportPattern = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+func NewLinkExtractor() *LinkExtractor {
+	return &LinkExtractor{
+		elements: make(map[string][]ElementExtractor),
+	}
+}
+
+// NewDefaultLinkExtractor returns an extractor knowing about every element
+// referencing a resource.
+func NewDefaultLinkExtractor() *LinkExtractor {
+	extractor := NewLinkExtractor()
+	extractor.Register("a", attributeExtractor(schemas.ResourceAnchor, "href"))
+	extractor.Register("area", attributeExtractor(schemas.ResourceAnchor, "href"))
+	extractor.Register("img", attributeExtractor(schemas.ResourceImage, "src"), srcsetExtractor(schemas.ResourceImage))
+	extractor.Register("source", attributeExtractor(schemas.ResourceMedia, "src"), srcsetExtractor(schemas.ResourceImage))
+	extractor.Register("video", attributeExtractor(schemas.ResourceMedia, "src"), attributeExtractor(schemas.ResourceImage, "poster"))
+	extractor.Register("audio", attributeExtractor(schemas.ResourceMedia, "src"))
+	extractor.Register("track", attributeExtractor(schemas.ResourceMedia, "src"))
+	extractor.Register("embed", attributeExtractor(schemas.ResourceMedia, "src"))
+	extractor.Register("object", attributeExtractor(schemas.ResourceMedia, "data"))
+	extractor.Register("script", attributeExtractor(schemas.ResourceScript, "src"))
+	extractor.Register("iframe", attributeExtractor(schemas.ResourceFrame, "src"))
+	extractor.Register("frame", attributeExtractor(schemas.ResourceFrame, "src"))
+	extractor.Register("link", linkElementExtractor)
+	extractor.Register("meta", metaRefreshExtractor)
+	return extractor
+}
+
+// Register adds extractors for the elements named tag.
+func (extractor *LinkExtractor) Register(tag string, extractors ...ElementExtractor) {
+	extractor.elements[tag] = append(extractor.elements[tag], extractors...)
+}
+
+// Extract returns every link of the HTML document along with what the page
+// declares about itself. Relative URLs are resolved against the <base> of the
+// document, or else base. Anchors come with their text, and the url() of
+// inline styles are extracted too.
+func (extractor *LinkExtractor) Extract(base *url.URL, document io.Reader) ([]PageLink, PageMeta) {
+	links := []PageLink{}
+	meta := PageMeta{Anchors: []string{}}
+	var anchorText []string
+	anchor := -1
+	inStyle, inTitle := false, false
+	baseFound := false
+
+	tokenizer := html.NewTokenizer(document)
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "style":
+				inStyle = tokenType == html.StartTagToken
+			case "title":
+				inTitle = tokenType == html.StartTagToken && meta.Title == ""
+			case "base":
+				// Only the first <base href> counts.
+				if href, found := tokenAttribute(token, "href"); found && !baseFound {
+					if parsedURL, err := url.Parse(strings.TrimSpace(href)); err == nil {
+						base = base.ResolveReference(parsedURL)
+						baseFound = true
+					}
+				}
+			case "meta":
+				name, _ := tokenAttribute(token, "name")
+				content, _ := tokenAttribute(token, "content")
+				name = strings.ToLower(name)
+				if (name == "robots" || name == robotsAgentToken) && robotsNoFollow(content) {
+					meta.NoFollow = true
+				}
+			}
+			for _, attr := range token.Attr {
+				if attr.Key == "id" || (attr.Key == "name" && token.Data == "a") {
+					meta.Anchors = append(meta.Anchors, attr.Val)
+				}
+				if attr.Key == "style" {
+					links = append(links, resolveLinks(base, cssLinks(attr.Val, token.Data, "style"))...)
+				}
+			}
+			found := 0
+			for _, extract := range extractor.elements[token.Data] {
+				resolved := resolveLinks(base, extract(token))
+				found += len(resolved)
+				links = append(links, resolved...)
+				for _, link := range resolved {
+					if link.Kind == schemas.ResourceLink && hasRel(link.Rel, "canonical") && meta.Canonical == "" {
+						meta.Canonical = link.Url
+					}
+				}
+			}
+			if token.Data == "a" && tokenType == html.StartTagToken && found > 0 {
+				anchor = len(links) - 1
+				anchorText = anchorText[:0]
+			}
+		case html.TextToken:
+			switch {
+			case inStyle:
+				links = append(links, resolveLinks(base, cssLinks(string(tokenizer.Text()), "style", ""))...)
+			case inTitle:
+				meta.Title = strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+			case anchor >= 0:
+				anchorText = append(anchorText, strings.Fields(string(tokenizer.Text()))...)
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "a":
+				if anchor >= 0 {
+					links[anchor].AnchorText = strings.Join(anchorText, " ")
+					anchor = -1
+				}
+			case "style":
+				inStyle = false
+			case "title":
+				inTitle = false
+			}
+		}
+	}
+	for i := range links {
+		if meta.NoFollow || hasRel(links[i].Rel, "nofollow") {
+			links[i].NoFollow = true
+		}
+	}
+	return links, meta
+}
+
+// ExtractCss returns the url() and @import references of a stylesheet.
+func (extractor *LinkExtractor) ExtractCss(base *url.URL, stylesheet io.Reader) []PageLink {
+	content, err := io.ReadAll(stylesheet)
+	if err != nil {
+		return []PageLink{}
+	}
+	return resolveLinks(base, cssLinks(string(content), "css", "url"))
+}
+
+// cssLinks returns the raw URLs referenced by CSS code.
+func cssLinks(css string, tag string, attribute string) []PageLink {
+	links := []PageLink{}
+	for _, pattern := range []*regexp.Regexp{cssImportPattern, cssUrlPattern} {
+		for _, match := range pattern.FindAllStringSubmatch(css, -1) {
+			raw := strings.Join(match[1:], "")
+			if raw == "" {
+				continue
+			}
+			kind := schemas.ResourceCss
+			if pattern == cssImportPattern {
+				kind = schemas.ResourceStylesheet
+			}
+			links = append(links, PageLink{Url: raw, Tag: tag, Attribute: attribute, Kind: kind})
+		}
+	}
+	return links
+}
+
+// resolveLinks resolves the raw URLs of links against base, dropping the ones
+// which cannot be checked over HTTP such as mailto: or data: URLs.
+func resolveLinks(base *url.URL, links []PageLink) []PageLink {
+	resolved := links[:0]
+	for _, link := range links {
+		parsedURL, err := url.Parse(strings.TrimSpace(link.Url))
+		if err != nil {
+			continue
+		}
+		target := base.ResolveReference(parsedURL)
+		if target.Scheme != "http" && target.Scheme != "https" {
+			continue
+		}
+		link.Url = target.String()
+		resolved = append(resolved, link)
+	}
+	return resolved
+}
+
+// attributeExtractor extracts the URL held by an attribute.
+func attributeExtractor(kind string, attribute string) ElementExtractor {
+	return func(token html.Token) []PageLink {
+		value, found := tokenAttribute(token, attribute)
+		if !found || strings.TrimSpace(value) == "" {
+			return nil
+		}
+		link := PageLink{Url: value, Tag: token.Data, Attribute: attribute, Kind: kind}
+		if rel, found := tokenAttribute(token, "rel"); found {
+			link.Rel = strings.Join(strings.Fields(rel), " ")
+		}
+		return []PageLink{link}
+	}
+}
+
+// srcsetExtractor extracts every candidate of a srcset attribute.
+func srcsetExtractor(kind string) ElementExtractor {
+	return func(token html.Token) []PageLink {
+		srcset, found := tokenAttribute(token, "srcset")
+		if !found {
+			return nil
+		}
+		var links []PageLink
+		for _, candidate := range srcsetUrls(srcset) {
+			links = append(links, PageLink{Url: candidate, Tag: token.Data, Attribute: "srcset", Kind: kind})
+		}
+		return links
+	}
+}
+
+// srcsetUrls returns the URLs of the candidates of a srcset, following the
+// HTML parsing algorithm: a URL runs up to the next whitespace and may hold
+// commas, except trailing ones, while the descriptors which follow it end
+// at the first comma outside parentheses.
+func srcsetUrls(srcset string) []string {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+	}
+	var urls []string
+	position := 0
+	for {
+		for position < len(srcset) && (isSpace(srcset[position]) || srcset[position] == ',') {
+			position++
+		}
+		if position >= len(srcset) {
+			return urls
+		}
+		start := position
+		for position < len(srcset) && !isSpace(srcset[position]) {
+			position++
+		}
+		url := srcset[start:position]
+		if trimmed := strings.TrimRight(url, ","); trimmed != url {
+			// No descriptors follow a URL ended by commas.
+			urls = append(urls, trimmed)
+			continue
+		}
+		urls = append(urls, url)
+
+		inParens := false
+		for position < len(srcset) && (inParens || srcset[position] != ',') {
+			switch srcset[position] {
+			case '(':
+				inParens = true
+			case ')':
+				inParens = false
+			}
+			position++
+		}
+	}
+}
+
+// linkElementExtractor extracts the href of <link>, a stylesheet or another
+// resource depending on its rel.
+func linkElementExtractor(token html.Token) []PageLink {
+	rel, _ := tokenAttribute(token, "rel")
+	kind := schemas.ResourceLink
+	if hasRel(rel, "stylesheet") {
+		kind = schemas.ResourceStylesheet
+	}
+	return attributeExtractor(kind, "href")(token)
+}
+
+// metaRefreshExtractor extracts the target of <meta http-equiv="refresh">.
+func metaRefreshExtractor(token html.Token) []PageLink {
+	equiv, _ := tokenAttribute(token, "http-equiv")
+	if !strings.EqualFold(equiv, "refresh") {
+		return nil
+	}
+	content, _ := tokenAttribute(token, "content")
+	_, target, found := strings.Cut(content, ";")
+	if !found {
+		return nil
+	}
+	target = strings.TrimSpace(target)
+	if len(target) < 4 || !strings.EqualFold(target[:4], "url=") {
+		return nil
+	}
+	target = strings.Trim(strings.TrimSpace(target[4:]), `"'`)
+	if target == "" {
+		return nil
+	}
+	return []PageLink{{Url: target, Tag: "meta", Attribute: "content", Kind: schemas.ResourceRefresh}}
+}
+
+// hasRel tells whether a rel attribute holds value.
+func hasRel(rel string, value string) bool {
+	for _, field := range strings.Fields(rel) {
+		if strings.EqualFold(field, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// robotsNoFollow tells whether robots directives forbid following links.
+func robotsNoFollow(directives string) bool {
+	for _, directive := range strings.Split(directives, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		// X-Robots-Tag directives may be prefixed with a user agent.
+		if agent, value, found := strings.Cut(directive, ":"); found {
+			if strings.TrimSpace(agent) != robotsAgentToken {
+				continue
+			}
+			directive = strings.TrimSpace(value)
+		}
+		if directive == "nofollow" || directive == "none" {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenAttribute(token html.Token, key string) (string, bool) {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSrcsetUrls(t *testing.T) {
+	tests := []struct {
+		name   string
+		srcset string
+		want   []string
+	}{
+		{"empty", "", nil},
+		{"blank", " \t\n", nil},
+		{"single url", "image.png", []string{"image.png"}},
+		{"width descriptors", "small.png 480w, large.png 1080w", []string{"small.png", "large.png"}},
+		{"density descriptors", "a.png 1x,b.png 2x", []string{"a.png", "b.png"}},
+		{"no spaces after commas", "a.png,b.png", []string{"a.png,b.png"}},
+		{"trailing comma", "a.png, b.png,", []string{"a.png", "b.png"}},
+		{"url ended by commas", "a.png,, b.png 2x", []string{"a.png", "b.png"}},
+		{"commas in a url", "/img?size=1,2 1x, /img?size=3,4 2x", []string{"/img?size=1,2", "/img?size=3,4"}},
+		{"data url", "data:image/png;base64,AAAA 1x, b.png 2x", []string{"data:image/png;base64,AAAA", "b.png"}},
+		{"leading separators", " ,, a.png 1x", []string{"a.png"}},
+		{"comma in parentheses", "a.png (min-width: 1px, x) 1x, b.png", []string{"a.png", "b.png"}},
+		{"newlines", "a.png 1x,\n  b.png 2x", []string{"a.png", "b.png"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := srcsetUrls(test.srcset); !slices.Equal(got, test.want) {
+				t.Errorf("srcsetUrls(%q) = %q, want %q", test.srcset, got, test.want)
+			}
+		})
+	}
+}
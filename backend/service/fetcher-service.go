@@ -1,23 +1,345 @@
 package service
 
-// import "fmt"
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
 
 type Fetcher interface {
-	// Fetch returns the body of URL and
-	// a slice of URLs found on that page.
-	Fetch(url string) (body string, urls []string, err error)
+	// Fetch returns the page found at the URL of request along with
+	// the links found on that page.
+	Fetch(ctx context.Context, request FetchRequest) (page Page, err error)
+}
+
+// Methods of a FetchRequest.
+const (
+	// Downloads and parses the page
+	MethodGet = "GET"
+	// Only checks that the URL is alive, with a ranged GET when HEAD fails
+	MethodHead = "HEAD"
+	// Recorded when a HEAD was retried as a ranged GET
+	MethodRangedGet = "GET (range)"
+)
+
+// defaultMaxBodySize caps the body downloaded by a GET.
+const defaultMaxBodySize = 10 * 1024 * 1024
+
+// FetchRequest is what a Fetcher is asked to retrieve.
+type FetchRequest struct {
+	Url string
+	// MethodGet when empty
+	Method string
+	// Bytes of the body downloaded, the rest being ignored. defaultMaxBodySize when 0
+	MaxBodySize int64
+	// Validators of the copy of the page already known, a GET being answered
+	// 304 Not Modified without a body when it did not change
+	ETag         string
+	LastModified string
+}
+
+// NewFetchRequest returns a request to download and parse the page at pageURL.
+func NewFetchRequest(pageURL string) FetchRequest {
+	return FetchRequest{Url: pageURL, Method: MethodGet}
+}
+
+// Page is the result of fetching a URL.
+type Page struct {
+	Url        string
+	Method     string // How the page was retrieved
+	FinalUrl   string // Set when the request was redirected, where the redirects led
+	StatusCode int
+	Header     http.Header
+	MediaType  string // Of the body, from its Content-Type
+	Body       string
+	Links      []PageLink
+	Duration   time.Duration
+	Attempts   int           // Made by the crawler, retries included
+	Unchanged  bool          // Answered 304, StatusCode being the one of the previous copy
+	Redirects  []RedirectHop // Followed before reaching FinalUrl
+	// Validators of the page, and the SHA-256 of its body when downloaded
+	ETag         string
+	LastModified string
+	ContentHash  string
+	PageMeta
+}
+
+// RedirectHop is a request of a redirect chain which was answered by a redirect.
+type RedirectHop struct {
+	Url        string
+	StatusCode int
+	Duration   time.Duration
 }
 
-// type result struct {
-// 	body string
-// 	urls []string
-// }
+// PageMeta is what a page declares about itself.
+type PageMeta struct {
+	Title     string
+	Canonical string
+	// Set by <meta name="robots"> or X-Robots-Tag, every link is then nofollow
+	NoFollow bool
+	// Ids and names fragments may point at, nil when the page is not HTML
+	Anchors []string
+}
+
+// PageLink is a link found on a page and where it was found.
+type PageLink struct {
+	Url        string
+	AnchorText string
+	Tag        string
+	Attribute  string
+	Rel        string
+	Kind       string // One of the schemas.Resource kinds
+	NoFollow   bool   // By its rel or by the page
+	Fragment   string // Stripped from Url by the crawler
+}
+
+// Urls returns the URL of every anchor of the page, the other resources
+// being left out as /scrap always did.
+func (page Page) Urls() []string {
+	urls := make([]string, 0, len(page.Links))
+	for _, link := range page.Links {
+		if link.Kind == schemas.ResourceAnchor {
+			urls = append(urls, link.Url)
+		}
+	}
+	return urls
+}
+
+// FetchError is the error returned by a Fetcher when a page cannot be retrieved.
+type FetchError struct {
+	Url        string
+	StatusCode int // 0 when no response was received
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("failed to fetch the page %s: %d %s", e.Url, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("failed to fetch the page %s: %v", e.Url, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
 
-// type fetcherService map[string]*result
+var (
+	ErrRedirectLoop       = errors.New("redirect loop")
+	ErrTooManyRedirects   = errors.New("too many redirects")
+	errRedirectNoLocation = errors.New("redirect without a valid Location")
+)
 
-// func (f fetcherService) Fetch(url string) (string, []string, error) {
-// 	if res, ok := f[url]; ok {
-// 		return res.body, res.urls, nil
-// 	}
-// 	return "", nil, fmt.Errorf("not found: %s", url)
-// }
+// maxRedirects is the length of the longest redirect chain followed.
+const maxRedirects = 10
+
+// crawlerUserAgent is the User-Agent of every request made by the crawler.
+const crawlerUserAgent = "SentryLink/1.0"
+
+type httpFetcher struct {
+	client    *http.Client
+	extractor *LinkExtractor
+}
+
+func NewHttpFetcher() Fetcher {
+	return &httpFetcher{
+		client: &http.Client{
+			Timeout: time.Second * 30,
+			// Redirects are followed by Fetch to record every hop.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		extractor: NewDefaultLinkExtractor(),
+	}
+}
+
+func (fetcher *httpFetcher) Fetch(ctx context.Context, request FetchRequest) (page Page, err error) {
+	pageURL := request.Url
+	page.Url = pageURL
+	start := time.Now()
+	defer func() {
+		page.Duration = time.Since(start)
+	}()
+
+	if _, err := url.Parse(pageURL); err != nil {
+		return page, &FetchError{Url: pageURL, Err: err}
+	}
+	if request.Method == MethodHead {
+		return fetcher.check(ctx, page)
+	}
+
+	page.Method = MethodGet
+	header := http.Header{}
+	if request.ETag != "" {
+		header.Set("If-None-Match", request.ETag)
+	}
+	if request.LastModified != "" {
+		header.Set("If-Modified-Since", request.LastModified)
+	}
+	conditional := len(header) > 0
+	resp, err := fetcher.follow(ctx, &page, header)
+	if err != nil {
+		return page, err
+	}
+	defer resp.Body.Close()
+	page.StatusCode = resp.StatusCode
+	page.Header = resp.Header
+	page.ETag = resp.Header.Get("ETag")
+	page.LastModified = resp.Header.Get("Last-Modified")
+	// Relative links are relative to where we landed.
+	base := resp.Request.URL
+
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		// The links are the ones of the copy already known.
+		return page, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return page, &FetchError{Url: pageURL, StatusCode: resp.StatusCode}
+	}
+
+	maxBodySize := request.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	// A truncated page is parsed as far as it was downloaded.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return page, &FetchError{Url: pageURL, StatusCode: resp.StatusCode, Err: err}
+	}
+	page.Body = string(body)
+	hash := sha256.Sum256(body)
+	page.ContentHash = hex.EncodeToString(hash[:])
+	// Only documents and stylesheets reference other resources.
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	page.MediaType = mediaType
+	switch {
+	case mediaType == "text/css":
+		page.Links = fetcher.extractor.ExtractCss(base, bytes.NewReader(body))
+	case mediaType == "" || mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		page.Links, page.PageMeta = fetcher.extractor.Extract(base, bytes.NewReader(body))
+	default:
+		page.Links = []PageLink{}
+	}
+	for _, directives := range resp.Header.Values("X-Robots-Tag") {
+		if robotsNoFollow(directives) {
+			page.NoFollow = true
+		}
+	}
+	if page.NoFollow {
+		for i := range page.Links {
+			page.Links[i].NoFollow = true
+		}
+	}
+	return page, nil
+}
+
+// check tells whether the URL of page is alive with a HEAD request, falling
+// back to a GET of its first byte when the server does not handle HEAD.
+func (fetcher *httpFetcher) check(ctx context.Context, page Page) (Page, error) {
+	page.Method = MethodHead
+	resp, err := fetcher.follow(ctx, &page, http.Header{})
+	if err == nil {
+		resp.Body.Close()
+		page.StatusCode = resp.StatusCode
+		page.Header = resp.Header
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return page, nil
+		}
+	}
+	if ctx.Err() != nil || !headUnreliable(page.StatusCode, err) {
+		if err == nil {
+			err = &FetchError{Url: page.Url, StatusCode: page.StatusCode}
+		}
+		return page, err
+	}
+
+	page = Page{Url: page.Url, Method: MethodRangedGet}
+	resp, err = fetcher.follow(ctx, &page, http.Header{"Range": {"bytes=0-0"}})
+	if err != nil {
+		return page, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	page.StatusCode = resp.StatusCode
+	page.Header = resp.Header
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return page, &FetchError{Url: page.Url, StatusCode: resp.StatusCode}
+	}
+	return page, nil
+}
+
+// headUnreliable tells whether the outcome of a HEAD request should be
+// confirmed with a GET: the answers which clearly tell the resource is gone
+// or the server is overloaded are trusted.
+func headUnreliable(statusCode int, err error) bool {
+	switch statusCode {
+	case http.StatusNotFound, http.StatusGone, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return false
+	}
+	// A redirect loop is one whatever the method.
+	return !errors.Is(err, ErrRedirectLoop) && !errors.Is(err, ErrTooManyRedirects)
+}
+
+// follow requests the URL of page with its method, following the redirects
+// and recording them in page until a response which is not a redirect.
+func (fetcher *httpFetcher) follow(ctx context.Context, page *Page, header http.Header) (*http.Response, error) {
+	method := http.MethodGet
+	if page.Method == MethodHead {
+		method = http.MethodHead
+	}
+	target := page.Url
+	requested := map[string]bool{}
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return nil, &FetchError{Url: page.Url, Err: err}
+		}
+		req.Header = header.Clone()
+		req.Header.Set("User-Agent", crawlerUserAgent)
+
+		start := time.Now()
+		resp, err := fetcher.client.Do(req)
+		if err != nil {
+			return nil, &FetchError{Url: page.Url, Err: err}
+		}
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || resp.StatusCode == http.StatusNotModified {
+			if len(page.Redirects) > 0 {
+				page.FinalUrl = target
+			}
+			return resp, nil
+		}
+
+		location, err := resp.Location()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		page.Redirects = append(page.Redirects, RedirectHop{
+			Url:        target,
+			StatusCode: resp.StatusCode,
+			Duration:   time.Since(start),
+		})
+		page.StatusCode = resp.StatusCode
+		if err != nil {
+			return nil, &FetchError{Url: page.Url, StatusCode: resp.StatusCode, Err: errRedirectNoLocation}
+		}
+
+		requested[target] = true
+		target = location.String()
+		page.FinalUrl = target
+		switch {
+		case requested[target]:
+			return nil, &FetchError{Url: page.Url, StatusCode: resp.StatusCode, Err: ErrRedirectLoop}
+		case len(page.Redirects) >= maxRedirects:
+			return nil, &FetchError{Url: page.Url, StatusCode: resp.StatusCode, Err: ErrTooManyRedirects}
+		}
+	}
+}
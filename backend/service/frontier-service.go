@@ -0,0 +1,154 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// frontierItem is a URL waiting to be fetched by the crawler.
+type frontierItem struct {
+	Url   string
+	Depth int
+	// Attempts already made, the host being overloaded
+	Attempts int
+	// Only checked, with a HEAD request, its links not being followed
+	Leaf bool
+}
+
+// frontier is the FIFO queue of the URLs left to fetch by a crawl. Past its
+// memory limit, the items pushed wait in temporary files until the ones in
+// memory are all popped.
+type frontier struct {
+	items []frontierItem
+	head  int
+	limit int // Items kept in memory, 0 meaning no limit
+
+	segments []*frontierSegment
+	spilled  int // Items waiting in the segments
+}
+
+// frontierSegment is a temporary file of spilled items, written until it
+// starts being read back.
+type frontierSegment struct {
+	file   *os.File
+	writer *bufio.Writer
+	reader *bufio.Reader
+	items  int
+}
+
+func newFrontier(limit int) *frontier {
+	return &frontier{limit: limit}
+}
+
+func (queue *frontier) Push(item frontierItem) {
+	if queue.spilled == 0 && (queue.limit <= 0 || len(queue.items)-queue.head < queue.limit) {
+		queue.items = append(queue.items, item)
+		return
+	}
+	last := len(queue.segments) - 1
+	if last < 0 || queue.segments[last].writer == nil {
+		queue.segments = append(queue.segments, newFrontierSegment())
+		last++
+	}
+	segment := queue.segments[last]
+	line, err := json.Marshal(item)
+	if err == nil {
+		_, err = segment.writer.Write(append(line, '\n'))
+	}
+	if err != nil {
+		panic("failed to spill the crawl frontier: " + err.Error())
+	}
+	segment.items++
+	queue.spilled++
+}
+
+func (queue *frontier) Peek() frontierItem {
+	queue.fill()
+	return queue.items[queue.head]
+}
+
+func (queue *frontier) Pop() frontierItem {
+	queue.fill()
+	item := queue.items[queue.head]
+	queue.items[queue.head] = frontierItem{}
+	queue.head++
+	// Reclaim the consumed part once it makes up half of the slice.
+	if queue.head > 1024 && queue.head*2 >= len(queue.items) {
+		queue.items = append([]frontierItem(nil), queue.items[queue.head:]...)
+		queue.head = 0
+	}
+	return item
+}
+
+func (queue *frontier) Len() int {
+	return len(queue.items) - queue.head + queue.spilled
+}
+
+// Close removes the temporary files of the frontier.
+func (queue *frontier) Close() {
+	for _, segment := range queue.segments {
+		segment.Close()
+	}
+	queue.segments = nil
+	queue.spilled = 0
+}
+
+// fill reads back the spilled items once the ones in memory are all popped,
+// up to the memory limit.
+func (queue *frontier) fill() {
+	if queue.head < len(queue.items) || queue.spilled == 0 {
+		return
+	}
+	queue.items, queue.head = queue.items[:0], 0
+	for queue.spilled > 0 && len(queue.items) < queue.limit {
+		segment := queue.segments[0]
+		queue.items = append(queue.items, segment.Read())
+		queue.spilled--
+		if segment.items == 0 {
+			segment.Close()
+			queue.segments = queue.segments[1:]
+		}
+	}
+}
+
+func newFrontierSegment() *frontierSegment {
+	file, err := os.CreateTemp("", "sentrylink-frontier-*")
+	if err != nil {
+		panic("failed to spill the crawl frontier: " + err.Error())
+	}
+	return &frontierSegment{file: file, writer: bufio.NewWriter(file)}
+}
+
+// Read reads back the next item of the segment, which is no longer
+// written to from then on.
+func (segment *frontierSegment) Read() frontierItem {
+	var err error
+	if segment.writer != nil {
+		err = segment.writer.Flush()
+		if err == nil {
+			_, err = segment.file.Seek(0, io.SeekStart)
+		}
+		segment.writer = nil
+		segment.reader = bufio.NewReader(segment.file)
+	}
+	var line []byte
+	if err == nil {
+		line, err = segment.reader.ReadBytes('\n')
+	}
+	var item frontierItem
+	if err == nil {
+		err = json.Unmarshal(line, &item)
+	}
+	if err != nil {
+		panic("failed to read the crawl frontier back: " + err.Error())
+	}
+	segment.items--
+	return item
+}
+
+func (segment *frontierSegment) Close() {
+	segment.file.Close()
+	os.Remove(segment.file.Name())
+}
@@ -13,8 +13,18 @@ type JWTService interface {
 	GenerateToken(userId string, name string, admin bool) string
 	ValidateToken(tokenString string) (*jwt.Token, error)
 	GetUserIdfromJWTToken(tokenString string) (userId uint64, err error)
+	GetAdminfromJWTToken(tokenString string) (admin bool, err error)
+	GenerateStreamTicket(userId string, crawlJobId uint64) (ticket string, expiresAt time.Time)
+	ValidateStreamTicket(ticket string, crawlJobId uint64) error
 }
 
+const (
+	// streamTicketTTL is how long a stream ticket may be used to open the
+	// event stream of a crawl.
+	streamTicketTTL      = time.Minute
+	streamTicketAudience = "crawl-events"
+)
+
 // jwtCustomClaims are custom claims extending default ones.
 type jwtCustomClaims struct {
 	Name  string `json:"name"`
@@ -97,3 +107,57 @@ func (jwtSrv *jwtService) GetUserIdfromJWTToken(tokenString string) (userId uint
 		return 0, err
 	}
 }
+
+func (jwtSrv *jwtService) GetAdminfromJWTToken(tokenString string) (admin bool, err error) {
+	token, err := jwtSrv.ValidateToken(tokenString)
+	if err != nil {
+		return false, err
+	}
+	if !token.Valid {
+		return false, fmt.Errorf("invalid token")
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	admin, _ = claims["admin"].(bool)
+	return admin, nil
+}
+
+// GenerateStreamTicket returns a ticket opening the event stream of a crawl
+// for a short while. It is signed with its own key so that it cannot be
+// used as a token.
+func (jwtSrv *jwtService) GenerateStreamTicket(userId string, crawlJobId uint64) (ticket string, expiresAt time.Time) {
+	expiresAt = time.Now().Add(streamTicketTTL)
+	claims := &jwt.StandardClaims{
+		Audience:  streamTicketAudience,
+		ExpiresAt: expiresAt.Unix(),
+		Id:        userId,
+		IssuedAt:  time.Now().Unix(),
+		Issuer:    jwtSrv.issuer,
+		Subject:   strconv.FormatUint(crawlJobId, 10),
+	}
+	ticket, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSrv.streamTicketKey())
+	if err != nil {
+		panic(err)
+	}
+	return ticket, expiresAt
+}
+
+func (jwtSrv *jwtService) ValidateStreamTicket(ticket string, crawlJobId uint64) error {
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(ticket, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSrv.streamTicketKey(), nil
+	})
+	if err != nil {
+		return err
+	}
+	if !claims.VerifyAudience(streamTicketAudience, true) || claims.Subject != strconv.FormatUint(crawlJobId, 10) {
+		return fmt.Errorf("stream ticket is not for crawl job %d", crawlJobId)
+	}
+	return nil
+}
+
+func (jwtSrv *jwtService) streamTicketKey() []byte {
+	return []byte(jwtSrv.secretKey + ":" + streamTicketAudience)
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+const (
+	defaultBrokenAfterFailures     = 2
+	defaultRecoveredAfterSuccesses = 2
+)
+
+// LinkStatePolicy tells when a failing URL is confirmed broken and when a
+// broken one is confirmed recovered.
+type LinkStatePolicy struct {
+	FailuresToBreak    int
+	FailureWindow      time.Duration // Minimum time between the first and the last failure
+	SuccessesToRecover int
+}
+
+type LinkStateService interface {
+	// Record moves the state of a URL according to the outcome of a check.
+	Record(linkId uint64, broken bool, policy LinkStatePolicy) schemas.LinkState
+	FindByState(state string) []schemas.LinkState
+	FindByLinks(linkIds []uint64) map[uint64]schemas.LinkState
+}
+
+type linkStateService struct {
+	repository repository.LinkStateRepository
+}
+
+func NewLinkStateService(linkStateRepository repository.LinkStateRepository) LinkStateService {
+	return &linkStateService{
+		repository: linkStateRepository,
+	}
+}
+
+func (service *linkStateService) Record(linkId uint64, broken bool, policy LinkStatePolicy) schemas.LinkState {
+	return service.repository.Transition(linkId, func(state *schemas.LinkState) {
+		nextLinkState(state, broken, policy, time.Now())
+	})
+}
+
+func (service *linkStateService) FindByState(state string) []schemas.LinkState {
+	return service.repository.FindByState(state)
+}
+
+func (service *linkStateService) FindByLinks(linkIds []uint64) map[uint64]schemas.LinkState {
+	states := make(map[uint64]schemas.LinkState, len(linkIds))
+	for _, state := range service.repository.FindByLinks(linkIds) {
+		states[state.LinkId] = state
+	}
+	return states
+}
+
+// nextLinkState applies the outcome of a check made at now to state.
+func nextLinkState(state *schemas.LinkState, broken bool, policy LinkStatePolicy, now time.Time) {
+	previous := state.State
+	state.CheckedAt = now
+	if broken {
+		state.ConsecutiveFailures++
+		state.ConsecutiveSuccesses = 0
+		if state.FailingSince == nil {
+			state.FailingSince = &now
+		}
+		confirmed := state.ConsecutiveFailures >= policy.FailuresToBreak && now.Sub(*state.FailingSince) >= policy.FailureWindow
+		switch {
+		case confirmed:
+			state.State = schemas.LinkStateBroken
+		case state.State != schemas.LinkStateBroken:
+			state.State = schemas.LinkStateSuspect
+		}
+	} else {
+		state.ConsecutiveSuccesses++
+		state.ConsecutiveFailures = 0
+		state.FailingSince = nil
+		switch state.State {
+		case schemas.LinkStateBroken:
+			if state.ConsecutiveSuccesses >= policy.SuccessesToRecover {
+				state.State = schemas.LinkStateRecovered
+			}
+		case schemas.LinkStateRecovered:
+			// Recovered on an earlier check, the URL keeps working.
+			if state.ConsecutiveSuccesses > policy.SuccessesToRecover {
+				state.State = schemas.LinkStateOk
+			}
+		case schemas.LinkStateSuspect, "":
+			state.State = schemas.LinkStateOk
+		}
+	}
+	if state.State != previous || state.ChangedAt.IsZero() {
+		state.ChangedAt = now
+	}
+}
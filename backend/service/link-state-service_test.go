@@ -0,0 +1,118 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+func TestNextLinkState(t *testing.T) {
+	type check struct {
+		broken bool
+		after  time.Duration // Since the first check
+		want   string
+	}
+	defaultPolicy := LinkStatePolicy{FailuresToBreak: 2, SuccessesToRecover: 2}
+	tests := []struct {
+		name   string
+		policy LinkStatePolicy
+		checks []check
+	}{
+		{
+			name:   "first success",
+			policy: defaultPolicy,
+			checks: []check{{false, 0, schemas.LinkStateOk}},
+		},
+		{
+			name:   "one-off failure",
+			policy: defaultPolicy,
+			checks: []check{{true, 0, schemas.LinkStateSuspect}, {false, time.Hour, schemas.LinkStateOk}},
+		},
+		{
+			name:   "confirmed failure",
+			policy: defaultPolicy,
+			checks: []check{{true, 0, schemas.LinkStateSuspect}, {true, time.Hour, schemas.LinkStateBroken}, {true, 2 * time.Hour, schemas.LinkStateBroken}},
+		},
+		{
+			name:   "broken at once",
+			policy: LinkStatePolicy{FailuresToBreak: 1, SuccessesToRecover: 1},
+			checks: []check{{true, 0, schemas.LinkStateBroken}},
+		},
+		{
+			name:   "failures within the window",
+			policy: LinkStatePolicy{FailuresToBreak: 2, FailureWindow: time.Hour, SuccessesToRecover: 2},
+			checks: []check{
+				{true, 0, schemas.LinkStateSuspect},
+				{true, 30 * time.Minute, schemas.LinkStateSuspect},
+				{true, time.Hour, schemas.LinkStateBroken},
+			},
+		},
+		{
+			name:   "window starting over after a success",
+			policy: LinkStatePolicy{FailuresToBreak: 1, FailureWindow: time.Hour, SuccessesToRecover: 2},
+			checks: []check{
+				{true, 0, schemas.LinkStateSuspect},
+				{false, 30 * time.Minute, schemas.LinkStateOk},
+				{true, time.Hour, schemas.LinkStateSuspect},
+				{true, 2 * time.Hour, schemas.LinkStateBroken},
+			},
+		},
+		{
+			name:   "recovery",
+			policy: defaultPolicy,
+			checks: []check{
+				{true, 0, schemas.LinkStateSuspect},
+				{true, time.Hour, schemas.LinkStateBroken},
+				{false, 2 * time.Hour, schemas.LinkStateBroken},
+				{false, 3 * time.Hour, schemas.LinkStateRecovered},
+				{false, 4 * time.Hour, schemas.LinkStateOk},
+			},
+		},
+		{
+			name:   "failure after a recovery",
+			policy: defaultPolicy,
+			checks: []check{
+				{true, 0, schemas.LinkStateSuspect},
+				{true, time.Hour, schemas.LinkStateBroken},
+				{false, 2 * time.Hour, schemas.LinkStateBroken},
+				{false, 3 * time.Hour, schemas.LinkStateRecovered},
+				{true, 4 * time.Hour, schemas.LinkStateSuspect},
+			},
+		},
+		{
+			name:   "success interrupting a recovery",
+			policy: defaultPolicy,
+			checks: []check{
+				{true, 0, schemas.LinkStateSuspect},
+				{true, time.Hour, schemas.LinkStateBroken},
+				{false, 2 * time.Hour, schemas.LinkStateBroken},
+				{true, 3 * time.Hour, schemas.LinkStateBroken},
+				{false, 4 * time.Hour, schemas.LinkStateBroken},
+			},
+		},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var state schemas.LinkState
+			for i, check := range test.checks {
+				now := start.Add(check.after)
+				previous := state.State
+				nextLinkState(&state, check.broken, test.policy, now)
+				if state.State != check.want {
+					t.Fatalf("check %d: state = %q, want %q", i, state.State, check.want)
+				}
+				if !state.CheckedAt.Equal(now) {
+					t.Errorf("check %d: checked at %v, want %v", i, state.CheckedAt, now)
+				}
+				if state.State != previous && !state.ChangedAt.Equal(now) {
+					t.Errorf("check %d: changed at %v, want %v", i, state.ChangedAt, now)
+				}
+				if state.State == previous && state.ChangedAt.Equal(now) && i > 0 {
+					t.Errorf("check %d: changed at %v although the state stayed %q", i, state.ChangedAt, state.State)
+				}
+			}
+		})
+	}
+}
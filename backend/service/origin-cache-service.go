@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// originCache holds what is known of every origin for a while, fetching it
+// once however many crawls ask for it meanwhile.
+type originCache[V any] struct {
+	ttl     time.Duration
+	fetch   func(ctx context.Context, origin string) V
+	mu      sync.Mutex
+	entries map[string]*originEntry[V]
+	// When the expired entries were last evicted
+	swept time.Time
+}
+
+// originEntry is the value of an origin, value and expires being set by the
+// fetch before it closes ready and only read afterwards.
+type originEntry[V any] struct {
+	ready   chan struct{}
+	value   V
+	expires time.Time
+}
+
+func newOriginCache[V any](ttl time.Duration, fetch func(ctx context.Context, origin string) V) *originCache[V] {
+	return &originCache[V]{
+		ttl:     ttl,
+		fetch:   fetch,
+		entries: make(map[string]*originEntry[V]),
+		swept:   time.Now(),
+	}
+}
+
+// Get returns the value of origin, false when ctx is done before it is
+// known. An expired value is fetched again once its fetch is over.
+func (cache *originCache[V]) Get(ctx context.Context, origin string) (V, bool) {
+	cache.mu.Lock()
+	entry, found := cache.entries[origin]
+	if found && entry.expired(time.Now()) {
+		found = false
+	}
+	if !found {
+		cache.sweep()
+		entry = &originEntry[V]{ready: make(chan struct{})}
+		cache.entries[origin] = entry
+		cache.mu.Unlock()
+
+		// The value is shared, a crawl being cancelled must not spoil it.
+		entry.value = cache.fetch(context.WithoutCancel(ctx), origin)
+		entry.expires = time.Now().Add(cache.ttl)
+		close(entry.ready)
+		return entry.value, true
+	}
+	cache.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.value, true
+	case <-ctx.Done():
+		var zero V
+		return zero, false
+	}
+}
+
+// expired tells whether the fetch of entry is over and its value outdated.
+func (entry *originEntry[V]) expired(now time.Time) bool {
+	select {
+	case <-entry.ready:
+		return now.After(entry.expires)
+	default:
+		return false
+	}
+}
+
+// sweep evicts the expired entries, the origins linked to by the crawls
+// being countless. It is called with mu held, at most once per ttl.
+func (cache *originCache[V]) sweep() {
+	now := time.Now()
+	if now.Sub(cache.swept) < cache.ttl {
+		return
+	}
+	cache.swept = now
+	for origin, entry := range cache.entries {
+		if entry.expired(now) {
+			delete(cache.entries, origin)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+// PageHistory is what the last crawl of a site found on its pages, so that
+// a recrawl only downloads the ones which changed since.
+type PageHistory interface {
+	// Previous returns the last copy of a page, false when there is none
+	// the server could be asked about.
+	Previous(url string) (PreviousPage, bool)
+	// Links returns the links found on the last copy of a page.
+	Links(previous PreviousPage) []PageLink
+}
+
+// PreviousPage is the last copy of a page downloaded by a crawl of its site.
+type PreviousPage struct {
+	StatusCode   int // Which a 304 stands for
+	ETag         string
+	LastModified string
+	ContentHash  string
+	PageMeta
+	crawlJobId uint64
+	url        string
+}
+
+// crawlPageHistory finds the pages of a crawl job in the last succeeded
+// crawl of its site.
+type crawlPageHistory struct {
+	jobId          uint64
+	linkRepository repository.LinkRepository
+	edgeRepository repository.CrawlEdgeRepository
+}
+
+// pageHistory returns the history of the pages of a job, nil when it
+// downloads all of them again.
+func pageHistory(job schemas.CrawlJob, linkRepository repository.LinkRepository, edgeRepository repository.CrawlEdgeRepository) PageHistory {
+	if job.Settings.FullRecrawl {
+		return nil
+	}
+	return NewCrawlPageHistory(job.Id, linkRepository, edgeRepository)
+}
+
+func NewCrawlPageHistory(jobId uint64, linkRepository repository.LinkRepository, edgeRepository repository.CrawlEdgeRepository) PageHistory {
+	return &crawlPageHistory{
+		jobId:          jobId,
+		linkRepository: linkRepository,
+		edgeRepository: edgeRepository,
+	}
+}
+
+func (history *crawlPageHistory) Previous(url string) (PreviousPage, bool) {
+	link, err := history.linkRepository.FindLastCrawled(history.jobId, url)
+	if err != nil {
+		return PreviousPage{}, false
+	}
+	return PreviousPage{
+		StatusCode:   int(link.StatusCode),
+		ETag:         link.ETag,
+		LastModified: link.LastModified,
+		ContentHash:  link.ContentHash,
+		PageMeta: PageMeta{
+			Canonical: link.Canonical,
+			Anchors:   link.Anchors,
+		},
+		crawlJobId: link.CrawlJobId,
+		url:        url,
+	}, true
+}
+
+func (history *crawlPageHistory) Links(previous PreviousPage) []PageLink {
+	edges := history.edgeRepository.FindBySource(previous.crawlJobId, previous.url)
+	links := make([]PageLink, 0, len(edges))
+	for _, edge := range edges {
+		links = append(links, PageLink{
+			Url:        edge.TargetUrl,
+			AnchorText: edge.AnchorText,
+			Tag:        edge.Tag,
+			Attribute:  edge.Attribute,
+			Rel:        edge.Rel,
+			Kind:       edge.Kind,
+			NoFollow:   edge.NoFollow,
+			Fragment:   edge.Fragment,
+		})
+	}
+	return links
+}
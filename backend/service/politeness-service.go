@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHostConcurrency       = 2
+	defaultHostRequestsPerSecond = 2
+
+	minHostBackoff = time.Second
+	maxHostBackoff = 10 * time.Minute
+)
+
+// HostPolicy are the limits a crawl applies to the requests made to a host.
+type HostPolicy struct {
+	MaxConcurrency    int
+	RequestsPerSecond float64
+	// MinInterval between two requests, the Crawl-delay of robots.txt
+	MinInterval time.Duration
+}
+
+// interval returns the time a token takes to come back in the bucket of the host.
+func (policy HostPolicy) interval() time.Duration {
+	interval := policy.MinInterval
+	if policy.RequestsPerSecond > 0 {
+		if perRequest := time.Duration(float64(time.Second) / policy.RequestsPerSecond); perRequest > interval {
+			interval = perRequest
+		}
+	}
+	return interval
+}
+
+// burst returns the size of the bucket of the host, a Crawl-delay allowing no burst.
+func (policy HostPolicy) burst() int {
+	if policy.MinInterval > 0 || policy.MaxConcurrency <= 1 {
+		return 1
+	}
+	return policy.MaxConcurrency
+}
+
+type hostLimiter struct {
+	active int
+	// Theoretical arrival time of the bucket: it is full when in the past.
+	tat          time.Time
+	blockedUntil time.Time
+	backoff      time.Duration
+	// Closed and replaced each time a request ends
+	released chan struct{}
+}
+
+// HostSchedule spaces out the requests several processes make to the same
+// host, repository.CrawlHostRepository keeping it in the database.
+type HostSchedule interface {
+	// Reserve books the next request to host, returning how long to wait
+	// before making it.
+	Reserve(host string, interval time.Duration) time.Duration
+	// Block keeps the requests to host from starting before wait elapsed.
+	Block(host string, wait time.Duration)
+}
+
+// Politeness limits the concurrency and the rate of the requests made to
+// every host. It is shared by all the crawls, so crawls targeting the same
+// host share its limits, and backs off when a host answers 429 or 503.
+type Politeness struct {
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+	// Shared with the other crawl workers, nil when crawling alone
+	schedule HostSchedule
+}
+
+func NewPoliteness() *Politeness {
+	return &Politeness{
+		hosts: make(map[string]*hostLimiter),
+	}
+}
+
+// NewSharedPoliteness returns a Politeness whose intervals between requests
+// and backoffs also apply to the other processes sharing schedule. The
+// concurrency limit of a host still applies to every process on its own.
+func NewSharedPoliteness(schedule HostSchedule) *Politeness {
+	politeness := NewPoliteness()
+	politeness.schedule = schedule
+	return politeness
+}
+
+// Acquire blocks until a request can be made to host under policy. Every
+// successful Acquire must be followed by a Release.
+func (politeness *Politeness) Acquire(ctx context.Context, host string, policy HostPolicy) error {
+	maxConcurrency := policy.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	interval, burst := policy.interval(), policy.burst()
+
+	politeness.mu.Lock()
+	limiter := politeness.limiter(host)
+	for {
+		now := time.Now()
+		var wait time.Duration
+		switch {
+		case limiter.blockedUntil.After(now):
+			wait = limiter.blockedUntil.Sub(now)
+		case limiter.active >= maxConcurrency:
+			// Woken up by Release
+		default:
+			// The bucket holds a token unless the theoretical arrival time
+			// is more than burst-1 intervals ahead.
+			available := limiter.tat.Add(-interval * time.Duration(burst-1))
+			if !available.After(now) {
+				if limiter.tat.Before(now) {
+					limiter.tat = now
+				}
+				limiter.tat = limiter.tat.Add(interval)
+				limiter.active++
+				politeness.mu.Unlock()
+				return politeness.reserve(ctx, host, interval)
+			}
+			wait = available.Sub(now)
+		}
+
+		released := limiter.released
+		politeness.mu.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-released:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		politeness.mu.Lock()
+	}
+}
+
+// Release ends a request made to host, backing off when the host answered
+// that it is overloaded.
+func (politeness *Politeness) Release(host string, statusCode int, retryAfter time.Duration) {
+	politeness.mu.Lock()
+	limiter := politeness.limiter(host)
+	limiter.active--
+	var wait time.Duration
+	switch {
+	case hostOverloaded(statusCode):
+		limiter.backoff = min(max(limiter.backoff*2, minHostBackoff), maxHostBackoff)
+		wait = min(max(retryAfter, limiter.backoff), maxHostBackoff)
+		if until := time.Now().Add(wait); until.After(limiter.blockedUntil) {
+			limiter.blockedUntil = until
+		}
+	case statusCode != 0:
+		limiter.backoff = 0
+	}
+	close(limiter.released)
+	limiter.released = make(chan struct{})
+	politeness.mu.Unlock()
+
+	if politeness.schedule != nil && wait > 0 {
+		politeness.schedule.Block(host, wait)
+	}
+}
+
+// reserve waits for the turn of a request to host among the processes
+// sharing the schedule, releasing it when ctx is done first.
+func (politeness *Politeness) reserve(ctx context.Context, host string, interval time.Duration) error {
+	if politeness.schedule == nil || interval <= 0 {
+		return nil
+	}
+	wait := politeness.schedule.Reserve(host, interval)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		politeness.Release(host, 0, 0)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limiter returns the limiter of host, forgetting the idle ones once in a while.
+func (politeness *Politeness) limiter(host string) *hostLimiter {
+	limiter, found := politeness.hosts[host]
+	if found {
+		return limiter
+	}
+	if len(politeness.hosts) >= 4096 {
+		now := time.Now()
+		for name, idle := range politeness.hosts {
+			if idle.active == 0 && idle.tat.Before(now) && idle.blockedUntil.Before(now) {
+				delete(politeness.hosts, name)
+			}
+		}
+	}
+	limiter = &hostLimiter{released: make(chan struct{})}
+	politeness.hosts[host] = limiter
+	return limiter
+}
+
+// hostOverloaded tells whether the host asked us to slow down.
+func hostOverloaded(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// retryAfter parses the Retry-After header, given in seconds or as a date.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
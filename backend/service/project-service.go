@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+var (
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrIgnoreRobotsForbidden is returned when robots.txt would be ignored
+	// for a project which is not verified.
+	ErrIgnoreRobotsForbidden = errors.New("robots.txt may only be ignored for verified projects")
+	// ErrIgnoreRobotsHost is returned when a crawl ignoring robots.txt for
+	// a project would leave the site of the project.
+	ErrIgnoreRobotsHost = errors.New("robots.txt may only be ignored on the site of the project")
+)
+
+// RequestUser is the user, read from the JWT claims, on whose behalf the
+// projects and the crawls are looked up and changed.
+type RequestUser struct {
+	Id    uint64
+	Admin bool
+}
+
+type ProjectService interface {
+	Save(user RequestUser, project schemas.Project) (schemas.Project, error)
+	Update(user RequestUser, project schemas.Project) error
+	Delete(user RequestUser, id uint64) error
+	FindAll(user RequestUser) []schemas.Project
+	FindById(id uint64) (schemas.Project, error)
+}
+
+type projectService struct {
+	repository repository.ProjectRepository
+}
+
+func NewProjectService(projectRepository repository.ProjectRepository) ProjectService {
+	return &projectService{
+		repository: projectRepository,
+	}
+}
+
+func (service *projectService) Save(user RequestUser, project schemas.Project) (schemas.Project, error) {
+	project.OwnerId = user.Id
+	// The sites of the admins are taken to be ours.
+	project.Verified = user.Admin
+	if err := checkIgnoreRobots(project); err != nil {
+		return schemas.Project{}, err
+	}
+	project.Id = service.repository.Save(project)
+	return service.FindById(project.Id)
+}
+
+func (service *projectService) Update(user RequestUser, project schemas.Project) error {
+	previous, err := service.findOwned(user, project.Id)
+	if err != nil {
+		return err
+	}
+	project.OwnerId = previous.OwnerId
+	project.CreatedAt = previous.CreatedAt
+	if !user.Admin {
+		project.Verified = previous.Verified
+	}
+	if err := checkIgnoreRobots(project); err != nil {
+		return err
+	}
+	service.repository.Update(project)
+	return nil
+}
+
+func (service *projectService) Delete(user RequestUser, id uint64) error {
+	project, err := service.findOwned(user, id)
+	if err != nil {
+		return err
+	}
+	service.repository.Delete(project)
+	return nil
+}
+
+func (service *projectService) FindAll(user RequestUser) []schemas.Project {
+	if user.Admin {
+		return service.repository.FindAll()
+	}
+	return service.repository.FindByOwner(user.Id)
+}
+
+func (service *projectService) FindById(id uint64) (schemas.Project, error) {
+	project, err := service.repository.FindById(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return schemas.Project{}, ErrProjectNotFound
+	}
+	return project, nil
+}
+
+// findOwned returns the project of id unless user may not change it, the
+// projects of the others being reported as not found.
+func (service *projectService) findOwned(user RequestUser, id uint64) (schemas.Project, error) {
+	project, err := service.FindById(id)
+	if err != nil {
+		return schemas.Project{}, err
+	}
+	if !user.Admin && project.OwnerId != user.Id {
+		return schemas.Project{}, ErrProjectNotFound
+	}
+	return project, nil
+}
+
+// checkIgnoreRobots tells whether the settings of project may be saved.
+func checkIgnoreRobots(project schemas.Project) error {
+	if project.Settings.IgnoreRobots && !project.Verified {
+		return ErrIgnoreRobotsForbidden
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = time.Second
+	maxRetryBackoff      = 30 * time.Second
+)
+
+// RetryPolicy tells how many times and how late a fetch failing with a
+// transient error is attempted again.
+type RetryPolicy struct {
+	MaxAttempts int // 1 or less meaning no retry
+	Backoff     time.Duration
+}
+
+// Delay returns how long to wait after a failed attempt: the backoff doubles
+// with every attempt and is jittered so that retries do not synchronize.
+func (policy RetryPolicy) Delay(attempt int) time.Duration {
+	backoff := policy.Backoff
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxRetryBackoff)
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// ClassifyError returns the schemas.ErrorClass of the outcome of a fetch,
+// an empty string when it succeeded.
+func ClassifyError(statusCode int, err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case err == nil && statusCode < 400, errors.Is(err, ErrSkippedByRobots):
+		return ""
+	case errors.Is(err, ErrSoft404):
+		return schemas.ErrorClassSoft404
+	case errors.Is(err, ErrRedirectLoop):
+		return schemas.ErrorClassRedirectLoop
+	case errors.Is(err, ErrTooManyRedirects):
+		return schemas.ErrorClassTooManyRedirects
+	case statusCode >= 500:
+		return schemas.ErrorClassServer
+	case statusCode >= 400:
+		return schemas.ErrorClassClient
+	case errors.As(err, &dnsErr):
+		return schemas.ErrorClassDns
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return schemas.ErrorClassRefused
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return schemas.ErrorClassTls
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return schemas.ErrorClassTimeout
+	default:
+		return schemas.ErrorClassNetwork
+	}
+}
+
+// transientError tells whether a fetch failing with an error of class may
+// succeed if attempted again.
+func transientError(class string) bool {
+	switch class {
+	case schemas.ErrorClassDns, schemas.ErrorClassRefused, schemas.ErrorClassTimeout,
+		schemas.ErrorClassServer, schemas.ErrorClassNetwork:
+		return true
+	}
+	return false
+}
+
+// sleep waits for delay, returning false when ctx is done first.
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
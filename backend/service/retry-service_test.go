@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+func TestClassifyError(t *testing.T) {
+	fetchError := func(err error) error {
+		return &FetchError{Url: "https://example.com/", Err: err}
+	}
+	tests := []struct {
+		name       string
+		statusCode int
+		err        error
+		want       string
+	}{
+		{"success", http.StatusOK, nil, ""},
+		{"redirect", http.StatusMovedPermanently, nil, ""},
+		{"skipped by robots", 0, ErrSkippedByRobots, ""},
+		{"not found", http.StatusNotFound, &FetchError{StatusCode: http.StatusNotFound}, schemas.ErrorClassClient},
+		{"client error without error", http.StatusGone, nil, schemas.ErrorClassClient},
+		{"server error", http.StatusBadGateway, &FetchError{StatusCode: http.StatusBadGateway}, schemas.ErrorClassServer},
+		{"soft 404", http.StatusOK, ErrSoft404, schemas.ErrorClassSoft404},
+		{"redirect loop", http.StatusFound, fetchError(ErrRedirectLoop), schemas.ErrorClassRedirectLoop},
+		{"too many redirects", http.StatusFound, fetchError(ErrTooManyRedirects), schemas.ErrorClassTooManyRedirects},
+		{"dns", 0, fetchError(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), schemas.ErrorClassDns},
+		{"refused", 0, fetchError(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), schemas.ErrorClassRefused},
+		{"unknown authority", 0, fetchError(x509.UnknownAuthorityError{}), schemas.ErrorClassTls},
+		{"hostname mismatch", 0, fetchError(x509.HostnameError{Host: "example.com"}), schemas.ErrorClassTls},
+		{"deadline", 0, fetchError(context.DeadlineExceeded), schemas.ErrorClassTimeout},
+		{"network timeout", 0, fetchError(&net.OpError{Op: "read", Err: timeoutError{}}), schemas.ErrorClassTimeout},
+		{"other", 0, fetchError(errors.New("connection reset")), schemas.ErrorClassNetwork},
+		{"wrapped", 0, fmt.Errorf("attempt 2: %w", fetchError(context.DeadlineExceeded)), schemas.ErrorClassTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ClassifyError(test.statusCode, test.err); got != test.want {
+				t.Errorf("ClassifyError(%d, %v) = %q, want %q", test.statusCode, test.err, got, test.want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error which timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTransientError(t *testing.T) {
+	tests := []struct {
+		class string
+		want  bool
+	}{
+		{schemas.ErrorClassDns, true},
+		{schemas.ErrorClassRefused, true},
+		{schemas.ErrorClassTimeout, true},
+		{schemas.ErrorClassServer, true},
+		{schemas.ErrorClassNetwork, true},
+		{schemas.ErrorClassClient, false},
+		{schemas.ErrorClassTls, false},
+		{schemas.ErrorClassSoft404, false},
+		{schemas.ErrorClassRedirectLoop, false},
+		{schemas.ErrorClassTooManyRedirects, false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := transientError(test.class); got != test.want {
+			t.Errorf("transientError(%q) = %v, want %v", test.class, got, test.want)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		attempt int
+		// The delay is jittered between half of the backoff and the backoff.
+		min, max time.Duration
+	}{
+		{"no backoff", 0, 1, 0, 0},
+		{"first attempt", time.Second, 1, 500 * time.Millisecond, time.Second},
+		{"second attempt", time.Second, 2, time.Second, 2 * time.Second},
+		{"third attempt", time.Second, 3, 2 * time.Second, 4 * time.Second},
+		{"capped", time.Second, 10, maxRetryBackoff / 2, maxRetryBackoff},
+		{"capped from the start", time.Minute, 1, maxRetryBackoff / 2, maxRetryBackoff},
+		{"many attempts", time.Millisecond, 1000, maxRetryBackoff / 2, maxRetryBackoff},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := RetryPolicy{MaxAttempts: 3, Backoff: test.backoff}
+			for i := 0; i < 100; i++ {
+				if delay := policy.Delay(test.attempt); delay < test.min || delay > test.max {
+					t.Fatalf("Delay(%d) = %v, want between %v and %v", test.attempt, delay, test.min, test.max)
+				}
+			}
+		})
+	}
+}
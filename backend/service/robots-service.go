@@ -0,0 +1,257 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// robotsAgentToken is the product token matched against the User-agent
+	// lines of robots.txt files.
+	robotsAgentToken = "sentrylink"
+	robotsMaxSize    = 500 * 1024
+	robotsTTL        = time.Hour
+	maxCrawlDelay    = time.Minute
+)
+
+// ErrSkippedByRobots is reported for the pages robots.txt forbids to fetch.
+var ErrSkippedByRobots = errors.New("skipped (robots)")
+
+// robotsRule is an Allow or Disallow line of robots.txt.
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// robotsRules are the rules of a robots.txt file applying to our user agent.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string
+}
+
+var (
+	robotsAllowAll    = &robotsRules{}
+	robotsDisallowAll = &robotsRules{rules: []robotsRule{{allow: false, length: 1, pattern: regexp.MustCompile("^/")}}}
+)
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// parseRobots parses a robots.txt file, keeping the groups of our user agent
+// or, when none names it, the ones of "*".
+func parseRobots(document io.Reader) *robotsRules {
+	var groups []*robotsGroup
+	var sitemaps []string
+	var current *robotsGroup
+	lastWasAgent := false
+
+	scanner := bufio.NewScanner(io.LimitReader(document, robotsMaxSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !lastWasAgent || current == nil {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			lastWasAgent = true
+			continue
+		case "allow", "disallow":
+			// An empty Disallow allows everything, it needs no rule.
+			if current != nil && value != "" {
+				current.rules = append(current.rules, robotsRule{
+					allow:   key == "allow",
+					length:  len(value),
+					pattern: robotsPattern(value),
+				})
+			}
+		case "crawl-delay":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if current != nil && err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			sitemaps = append(sitemaps, value)
+		}
+		lastWasAgent = false
+	}
+
+	rules := &robotsRules{sitemaps: sitemaps}
+	matched := false
+	for _, wanted := range []func(agent string) bool{
+		func(agent string) bool { return strings.HasPrefix(agent, robotsAgentToken) },
+		func(agent string) bool { return agent == "*" },
+	} {
+		for _, group := range groups {
+			for _, agent := range group.agents {
+				if wanted(agent) {
+					matched = true
+					rules.rules = append(rules.rules, group.rules...)
+					if group.crawlDelay > rules.crawlDelay {
+						rules.crawlDelay = group.crawlDelay
+					}
+					break
+				}
+			}
+		}
+		if matched {
+			break
+		}
+	}
+	if rules.crawlDelay > maxCrawlDelay {
+		rules.crawlDelay = maxCrawlDelay
+	}
+	return rules
+}
+
+// robotsPattern compiles a path pattern where * matches any sequence of
+// characters and a trailing $ anchors the end of the URL.
+func robotsPattern(path string) *regexp.Regexp {
+	anchored := strings.HasSuffix(path, "$")
+	path = strings.TrimSuffix(path, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(path), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allowed applies the longest matching rule to the path and query of a URL,
+// Allow winning ties.
+func (rules *robotsRules) allowed(target *url.URL) bool {
+	path := target.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+	if target.RawQuery != "" {
+		path += "?" + target.RawQuery
+	}
+
+	allowed, longest := true, -1
+	for _, rule := range rules.rules {
+		if rule.length < longest || !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > longest || rule.allow {
+			allowed, longest = rule.allow, rule.length
+		}
+	}
+	return allowed
+}
+
+// RobotsCache fetches the robots.txt of every host once in a while, and is
+// shared by all the crawls.
+type RobotsCache struct {
+	client *http.Client
+	rules  *originCache[*robotsRules]
+}
+
+func NewRobotsCache() *RobotsCache {
+	cache := &RobotsCache{
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+	cache.rules = newOriginCache(robotsTTL, cache.fetch)
+	return cache
+}
+
+// Allowed tells whether robots.txt lets the crawler fetch rawURL.
+func (cache *RobotsCache) Allowed(ctx context.Context, rawURL string) bool {
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return true
+	}
+	rules, err := cache.originRules(ctx, target)
+	if err != nil {
+		return true
+	}
+	return rules.allowed(target)
+}
+
+// CrawlDelay returns the Crawl-delay robots.txt sets for the host of rawURL.
+func (cache *RobotsCache) CrawlDelay(ctx context.Context, rawURL string) time.Duration {
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return 0
+	}
+	rules, err := cache.originRules(ctx, target)
+	if err != nil {
+		return 0
+	}
+	return rules.crawlDelay
+}
+
+// Sitemaps returns the sitemaps robots.txt names for the host of rawURL.
+func (cache *RobotsCache) Sitemaps(ctx context.Context, rawURL string) []string {
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return nil
+	}
+	rules, err := cache.originRules(ctx, target)
+	if err != nil {
+		return nil
+	}
+	return append([]string{}, rules.sitemaps...)
+}
+
+func (cache *RobotsCache) originRules(ctx context.Context, target *url.URL) (*robotsRules, error) {
+	rules, ok := cache.rules.Get(ctx, target.Scheme+"://"+target.Host)
+	if !ok {
+		return nil, ctx.Err()
+	}
+	return rules, nil
+}
+
+// fetch retrieves the robots.txt of origin. A missing file allows
+// everything while a server error disallows everything.
+func (cache *RobotsCache) fetch(ctx context.Context, origin string) *robotsRules {
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return robotsAllowAll
+	}
+	req.Header.Set("User-Agent", crawlerUserAgent)
+
+	resp, err := cache.client.Do(req)
+	if err != nil {
+		// The pages of an unreachable host are reported broken when fetched.
+		return robotsAllowAll
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return parseRobots(resp.Body)
+	case resp.StatusCode >= 500:
+		return robotsDisallowAll
+	default:
+		return robotsAllowAll
+	}
+}
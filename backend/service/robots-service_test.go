@@ -0,0 +1,84 @@
+package service
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		robots string
+		url    string
+		want   bool
+	}{
+		{"no rules", "", "/a", true},
+		{"disallowed prefix", "User-agent: *\nDisallow: /private", "/private/a", false},
+		{"other path", "User-agent: *\nDisallow: /private", "/public", true},
+		{"empty disallow", "User-agent: *\nDisallow:", "/a", true},
+		{"disallow all", "User-agent: *\nDisallow: /", "/", false},
+		{"robots.txt always allowed", "User-agent: *\nDisallow: /", "/robots.txt", true},
+		{"longest rule wins", "User-agent: *\nDisallow: /a\nAllow: /a/b", "/a/b/c", true},
+		{"longest disallow wins", "User-agent: *\nAllow: /a\nDisallow: /a/b", "/a/b/c", false},
+		{"allow wins ties", "User-agent: *\nDisallow: /a\nAllow: /a", "/a", true},
+		{"wildcard", "User-agent: *\nDisallow: /*.pdf", "/docs/file.pdf", false},
+		{"end anchor", "User-agent: *\nDisallow: /*.pdf$", "/docs/file.pdf?page=2", true},
+		{"query matched", "User-agent: *\nDisallow: /search?q=", "/search?q=term", false},
+		{"comments", "User-agent: * # everyone\nDisallow: /private # not this", "/private", false},
+		{"our agent preferred", "User-agent: *\nDisallow: /\n\nUser-agent: SentryLink\nDisallow: /private", "/public", true},
+		{"our agent by prefix", "User-agent: sentrylink-bot\nDisallow: /a", "/a", false},
+		{"groups of our agent merged", "User-agent: sentrylink\nDisallow: /a\n\nUser-agent: sentrylink\nDisallow: /b", "/b", false},
+		{"other agents ignored", "User-agent: otherbot\nDisallow: /", "/a", true},
+		{"agents sharing a group", "User-agent: otherbot\nUser-agent: sentrylink\nDisallow: /a", "/a", false},
+		{"rules before any agent", "Disallow: /a\nUser-agent: *\nDisallow: /b", "/a", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := url.Parse("https://example.com" + test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules := parseRobots(strings.NewReader(test.robots))
+			if got := rules.allowed(target); got != test.want {
+				t.Errorf("allowed(%q) = %v, want %v", test.url, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		name       string
+		robots     string
+		crawlDelay time.Duration
+		sitemaps   []string
+	}{
+		{"nothing", "", 0, nil},
+		{"crawl delay", "User-agent: *\nCrawl-delay: 2", 2 * time.Second, nil},
+		{"fractional crawl delay", "User-agent: *\nCrawl-delay: 0.5", 500 * time.Millisecond, nil},
+		{"invalid crawl delay", "User-agent: *\nCrawl-delay: soon", 0, nil},
+		{"crawl delay capped", "User-agent: *\nCrawl-delay: 3600", maxCrawlDelay, nil},
+		{"crawl delay of other agents", "User-agent: otherbot\nCrawl-delay: 10", 0, nil},
+		{"longest crawl delay", "User-agent: sentrylink\nCrawl-delay: 1\n\nUser-agent: sentrylink\nCrawl-delay: 3", 3 * time.Second, nil},
+		{
+			"sitemaps of any group",
+			"Sitemap: https://example.com/a.xml\nUser-agent: otherbot\nSitemap: https://example.com/b.xml",
+			0,
+			[]string{"https://example.com/a.xml", "https://example.com/b.xml"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(test.robots))
+			if rules.crawlDelay != test.crawlDelay {
+				t.Errorf("crawl delay = %v, want %v", rules.crawlDelay, test.crawlDelay)
+			}
+			if !slices.Equal(rules.sitemaps, test.sitemaps) {
+				t.Errorf("sitemaps = %q, want %q", rules.sitemaps, test.sitemaps)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+type scopeVerdict int
+
+const (
+	scopeInside   scopeVerdict = iota
+	scopeExternal              // Checked but not crawled further
+	scopeExcluded              // Not fetched at all
+)
+
+// Scope decides which of the URLs found by a crawl are fetched, compiled
+// from its schemas.CrawlScope. A nil Scope lets every URL in.
+type Scope struct {
+	hosts        []string // Lower-cased, a leading "*." also matching the subdomains
+	includePaths []string
+	excludePaths []string
+	include      []*regexp.Regexp
+	exclude      []*regexp.Regexp
+	external     string
+	query        string
+}
+
+// NewScope compiles the scope of a crawl, any host being allowed when it
+// lists none.
+func NewScope(scope schemas.CrawlScope) (*Scope, error) {
+	compiled := &Scope{
+		includePaths: scope.IncludePaths,
+		excludePaths: scope.ExcludePaths,
+		external:     scope.External,
+		query:        scope.Query,
+	}
+	for _, host := range scope.AllowedHosts {
+		compiled.hosts = append(compiled.hosts, strings.ToLower(strings.TrimSpace(host)))
+	}
+	var err error
+	if compiled.include, err = compilePatterns(scope.IncludePatterns); err != nil {
+		return nil, err
+	}
+	if compiled.exclude, err = compilePatterns(scope.ExcludePatterns); err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// Rewrite applies the query string rule of the scope to a URL.
+func (scope *Scope) Rewrite(rawURL string) string {
+	if scope == nil || scope.query != schemas.QueryStrip {
+		return rawURL
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.RawQuery == "" && !target.ForceQuery) {
+		return rawURL
+	}
+	target.RawQuery = ""
+	target.ForceQuery = false
+	return target.String()
+}
+
+// check tells what to do with a URL found by the crawl. The path and query
+// rules only apply to the allowed hosts, the exclude patterns to any URL.
+func (scope *Scope) check(rawURL string) scopeVerdict {
+	if scope == nil {
+		return scopeInside
+	}
+	for _, re := range scope.exclude {
+		if re.MatchString(rawURL) {
+			return scopeExcluded
+		}
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return scopeExcluded
+	}
+
+	if !scope.allowedHost(target.Hostname()) {
+		switch scope.external {
+		case schemas.ExternalFollow:
+			return scopeInside
+		case schemas.ExternalIgnore:
+			return scopeExcluded
+		default:
+			return scopeExternal
+		}
+	}
+	if scope.query == schemas.QuerySkip && target.RawQuery != "" {
+		return scopeExcluded
+	}
+	path := target.EscapedPath()
+	for _, prefix := range scope.excludePaths {
+		if strings.HasPrefix(path, prefix) {
+			return scopeExcluded
+		}
+	}
+	if len(scope.includePaths) > 0 && !hasAnyPrefix(path, scope.includePaths) {
+		return scopeExcluded
+	}
+	if len(scope.include) > 0 && !matchesAny(rawURL, scope.include) {
+		return scopeExcluded
+	}
+	return scopeInside
+}
+
+func (scope *Scope) allowedHost(host string) bool {
+	if len(scope.hosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range scope.hosts {
+		if domain, wildcard := strings.CutPrefix(allowed, "*."); wildcard {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(s string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+func TestScopeCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope schemas.CrawlScope
+		url   string
+		want  scopeVerdict
+	}{
+		{"no rules", schemas.CrawlScope{}, "https://other.com/a", scopeInside},
+		{"allowed host", schemas.CrawlScope{AllowedHosts: []string{"example.com"}}, "https://example.com/a", scopeInside},
+		{"host case", schemas.CrawlScope{AllowedHosts: []string{"Example.com"}}, "https://EXAMPLE.com/a", scopeInside},
+		{"host port ignored", schemas.CrawlScope{AllowedHosts: []string{"example.com"}}, "https://example.com:8443/a", scopeInside},
+		{"external checked by default", schemas.CrawlScope{AllowedHosts: []string{"example.com"}}, "https://other.com/a", scopeExternal},
+		{"external followed", schemas.CrawlScope{AllowedHosts: []string{"example.com"}, External: schemas.ExternalFollow}, "https://other.com/a", scopeInside},
+		{"external ignored", schemas.CrawlScope{AllowedHosts: []string{"example.com"}, External: schemas.ExternalIgnore}, "https://other.com/a", scopeExcluded},
+		{"subdomain not allowed", schemas.CrawlScope{AllowedHosts: []string{"example.com"}}, "https://www.example.com/a", scopeExternal},
+		{"wildcard subdomain", schemas.CrawlScope{AllowedHosts: []string{"*.example.com"}}, "https://blog.example.com/a", scopeInside},
+		{"wildcard domain itself", schemas.CrawlScope{AllowedHosts: []string{"*.example.com"}}, "https://example.com/a", scopeInside},
+		{"wildcard suffix only", schemas.CrawlScope{AllowedHosts: []string{"*.example.com"}}, "https://badexample.com/a", scopeExternal},
+		{"included path", schemas.CrawlScope{IncludePaths: []string{"/docs"}}, "https://example.com/docs/a", scopeInside},
+		{"path not included", schemas.CrawlScope{IncludePaths: []string{"/docs"}}, "https://example.com/blog", scopeExcluded},
+		{"excluded path", schemas.CrawlScope{ExcludePaths: []string{"/docs/old"}}, "https://example.com/docs/old/a", scopeExcluded},
+		{"exclusion wins", schemas.CrawlScope{IncludePaths: []string{"/docs"}, ExcludePaths: []string{"/docs/old"}}, "https://example.com/docs/old", scopeExcluded},
+		{"paths only on allowed hosts", schemas.CrawlScope{AllowedHosts: []string{"example.com"}, IncludePaths: []string{"/docs"}}, "https://other.com/blog", scopeExternal},
+		{"included pattern", schemas.CrawlScope{IncludePatterns: []string{`\.html$`}}, "https://example.com/a.html", scopeInside},
+		{"pattern not included", schemas.CrawlScope{IncludePatterns: []string{`\.html$`}}, "https://example.com/a.pdf", scopeExcluded},
+		{"excluded pattern on any host", schemas.CrawlScope{AllowedHosts: []string{"example.com"}, ExcludePatterns: []string{`logout`}}, "https://other.com/logout", scopeExcluded},
+		{"query kept", schemas.CrawlScope{Query: schemas.QueryKeep}, "https://example.com/a?page=2", scopeInside},
+		{"query skipped", schemas.CrawlScope{Query: schemas.QuerySkip}, "https://example.com/a?page=2", scopeExcluded},
+		{"no query to skip", schemas.CrawlScope{Query: schemas.QuerySkip}, "https://example.com/a", scopeInside},
+		{"unparsable url", schemas.CrawlScope{}, "https://[::1/a", scopeExcluded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scope, err := NewScope(test.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := scope.check(test.url); got != test.want {
+				t.Errorf("check(%q) = %v, want %v", test.url, got, test.want)
+			}
+		})
+	}
+}
+
+func TestScopeRewrite(t *testing.T) {
+	tests := []struct {
+		query string
+		url   string
+		want  string
+	}{
+		{schemas.QueryKeep, "https://example.com/a?page=2", "https://example.com/a?page=2"},
+		{schemas.QueryStrip, "https://example.com/a?page=2", "https://example.com/a"},
+		{schemas.QueryStrip, "https://example.com/a?", "https://example.com/a"},
+		{schemas.QueryStrip, "https://example.com/a", "https://example.com/a"},
+	}
+	for _, test := range tests {
+		scope, err := NewScope(schemas.CrawlScope{Query: test.query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scope.Rewrite(test.url); got != test.want {
+			t.Errorf("Rewrite(%q) with query %s = %q, want %q", test.url, test.query, got, test.want)
+		}
+	}
+}
+
+func TestNewScopeInvalidPattern(t *testing.T) {
+	if _, err := NewScope(schemas.CrawlScope{ExcludePatterns: []string{"("}}); err == nil {
+		t.Error("NewScope accepted an invalid pattern")
+	}
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	"github.com/Tom-Mendy/SentryLink/repository"
 	"github.com/Tom-Mendy/SentryLink/schemas"
 )
@@ -10,16 +12,18 @@ type ScrapService interface {
 	Update(schemas.LinkToLinkUrl) error
 	Delete(schemas.LinkToLinkUrl) error
 	FindAll() []schemas.LinkToLinkUrl
-	// Scrap(string) (string, error)
+	Scrap(ctx context.Context, pageURL string) ([]string, error)
 }
 
 type scrapService struct {
 	repository repository.ScrapRepository
+	fetcher    Fetcher
 }
 
-func NewScrapService(scrapRepository repository.ScrapRepository) ScrapService {
+func NewScrapService(scrapRepository repository.ScrapRepository, fetcher Fetcher) ScrapService {
 	return &scrapService{
 		repository: scrapRepository,
+		fetcher:    fetcher,
 	}
 }
 
@@ -42,7 +46,10 @@ func (service *scrapService) FindAll() []schemas.LinkToLinkUrl {
 	return service.repository.FindAll()
 }
 
-// func (service *scrapService) Scrap(pageURL string) (string, error) {
-// 	return ExtractLinks(pageURL)
-// }
-
+func (service *scrapService) Scrap(ctx context.Context, pageURL string) ([]string, error) {
+	page, err := service.fetcher.Fetch(ctx, NewFetchRequest(pageURL))
+	if err != nil {
+		return nil, err
+	}
+	return page.Urls(), nil
+}
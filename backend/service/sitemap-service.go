@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Limits of the sitemap protocol
+	maxSitemapSize = 50 * 1024 * 1024
+	maxSitemapUrls = 50000
+	// maxSitemaps bounds the sitemaps read for a site, indexes included.
+	maxSitemaps = 50
+)
+
+// SitemapUrl is a URL listed by a sitemap.
+type SitemapUrl struct {
+	Url     string
+	Sitemap string
+}
+
+// sitemapDocument is either a urlset or a sitemapindex.
+type sitemapDocument struct {
+	XMLName  xml.Name
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+	Urls []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+// SitemapReader reads the sitemaps of a site, following sitemap indexes and
+// uncompressing gzipped sitemaps.
+type SitemapReader struct {
+	client *http.Client
+}
+
+func NewSitemapReader() *SitemapReader {
+	return &SitemapReader{
+		client: &http.Client{
+			Timeout: time.Second * 30,
+		},
+	}
+}
+
+// SitemapLocations returns the sitemaps of the site of seedURL: the ones
+// named by its robots.txt and /sitemap.xml.
+func SitemapLocations(ctx context.Context, robots *RobotsCache, seedURL string) []string {
+	seed, err := url.Parse(seedURL)
+	if err != nil || seed.Host == "" {
+		return nil
+	}
+	locations := robots.Sitemaps(ctx, seedURL)
+	fallback := seed.Scheme + "://" + seed.Host + "/sitemap.xml"
+	for _, location := range locations {
+		if location == fallback {
+			return locations
+		}
+	}
+	return append(locations, fallback)
+}
+
+// Read returns at most limit URLs listed by the sitemaps, along with the
+// sitemaps actually read. Sitemaps which cannot be read are ignored.
+func (reader *SitemapReader) Read(ctx context.Context, sitemaps []string, limit int) ([]SitemapUrl, []string) {
+	if limit <= 0 || limit > maxSitemapUrls {
+		limit = maxSitemapUrls
+	}
+	urls := []SitemapUrl{}
+	read := []string{}
+	seen := make(map[string]bool)
+	queue := append([]string{}, sitemaps...)
+	for len(queue) > 0 && len(seen) < maxSitemaps && len(urls) < limit && ctx.Err() == nil {
+		sitemap := queue[0]
+		queue = queue[1:]
+		if seen[sitemap] {
+			continue
+		}
+		seen[sitemap] = true
+
+		document, err := reader.fetch(ctx, sitemap)
+		if err != nil {
+			continue
+		}
+		read = append(read, sitemap)
+		for _, child := range document.Sitemaps {
+			if loc := strings.TrimSpace(child.Loc); loc != "" {
+				queue = append(queue, loc)
+			}
+		}
+		for _, listed := range document.Urls {
+			loc := strings.TrimSpace(listed.Loc)
+			if loc == "" {
+				continue
+			}
+			urls = append(urls, SitemapUrl{Url: loc, Sitemap: sitemap})
+			if len(urls) >= limit {
+				break
+			}
+		}
+	}
+	return urls, read
+}
+
+func (reader *SitemapReader) fetch(ctx context.Context, sitemap string) (sitemapDocument, error) {
+	var document sitemapDocument
+	req, err := http.NewRequestWithContext(ctx, "GET", sitemap, nil)
+	if err != nil {
+		return document, err
+	}
+	req.Header.Set("User-Agent", crawlerUserAgent)
+
+	resp, err := reader.client.Do(req)
+	if err != nil {
+		return document, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return document, &FetchError{Url: sitemap, StatusCode: resp.StatusCode}
+	}
+
+	// Gzipped sitemaps are recognized by their magic number, whatever their
+	// name or content type.
+	body := bufio.NewReader(io.LimitReader(resp.Body, maxSitemapSize))
+	var content io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipped, err := gzip.NewReader(body)
+		if err != nil {
+			return document, err
+		}
+		defer gzipped.Close()
+		content = io.LimitReader(gzipped, maxSitemapSize)
+	}
+
+	decoder := xml.NewDecoder(content)
+	decoder.Strict = false
+	err = decoder.Decode(&document)
+	return document, err
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	soft404ProbeTTL = time.Hour
+	// soft404Similarity is the share of words a page must have in common
+	// with the not found page of its host to be one.
+	soft404Similarity = 0.9
+	soft404MaxWords   = 5000
+)
+
+// ErrSoft404 is reported for the pages answered 200 which are not found pages.
+var ErrSoft404 = errors.New("soft 404")
+
+// DefaultSoft404Patterns are matched against the title of the pages when a
+// crawl sets no pattern.
+var DefaultSoft404Patterns = []string{
+	`(?i)\bpage not found\b`,
+	`(?i)^(error )?404\b`,
+	`(?i)\b404 (error|not found)\b`,
+	`(?i)\bnot found\b.*\b404\b`,
+}
+
+// soft404Probe is what a host answers for a URL which cannot exist.
+type soft404Probe struct {
+	// Set when the host answers 200 for missing pages
+	found    bool
+	finalUrl string
+	words    map[string]bool
+}
+
+// Soft404Detector tells the not found pages answered 200 apart, by matching
+// patterns and by comparing them with what their host answers for a random
+// URL. The probes are shared by all the crawls.
+type Soft404Detector struct {
+	fetcher Fetcher
+	probes  *originCache[*soft404Probe]
+}
+
+// Soft404Patterns are the patterns of a crawl, matched against the title
+// and the text of the pages.
+type Soft404Patterns struct {
+	Title []*regexp.Regexp
+	Body  []*regexp.Regexp
+}
+
+func NewSoft404Detector(fetcher Fetcher) *Soft404Detector {
+	detector := &Soft404Detector{
+		fetcher: fetcher,
+	}
+	detector.probes = newOriginCache(soft404ProbeTTL, detector.fetchProbe)
+	return detector
+}
+
+// CompileSoft404Patterns compiles the title and body patterns of a crawl,
+// the default title patterns being used when none is given.
+func CompileSoft404Patterns(titlePatterns []string, bodyPatterns []string) (Soft404Patterns, error) {
+	if len(titlePatterns) == 0 {
+		titlePatterns = DefaultSoft404Patterns
+	}
+	patterns := Soft404Patterns{}
+	for _, expr := range titlePatterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return patterns, err
+		}
+		patterns.Title = append(patterns.Title, pattern)
+	}
+	for _, expr := range bodyPatterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return patterns, err
+		}
+		patterns.Body = append(patterns.Body, pattern)
+	}
+	return patterns, nil
+}
+
+// IsSoft404 tells whether an HTML page fetched successfully is a not found page.
+func (detector *Soft404Detector) IsSoft404(ctx context.Context, page Page, patterns Soft404Patterns) bool {
+	if page.StatusCode != http.StatusOK || page.Anchors == nil {
+		return false
+	}
+	for _, pattern := range patterns.Title {
+		if pattern.MatchString(page.Title) {
+			return true
+		}
+	}
+	text := pageText(page.Body)
+	for _, pattern := range patterns.Body {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+
+	target, err := url.Parse(page.Url)
+	if err != nil || target.Host == "" {
+		return false
+	}
+	probe := detector.probe(ctx, target.Scheme+"://"+target.Host)
+	if probe == nil || !probe.found {
+		return false
+	}
+	// The host redirects the missing pages, to its home page for instance.
+	if probe.finalUrl != "" {
+		return page.FinalUrl == probe.finalUrl
+	}
+	return similarity(words(text), probe.words) >= soft404Similarity
+}
+
+// probe returns what origin answers for a random URL, nil when ctx is done
+// before it is known.
+func (detector *Soft404Detector) probe(ctx context.Context, origin string) *soft404Probe {
+	probe, _ := detector.probes.Get(ctx, origin)
+	return probe
+}
+
+func (detector *Soft404Detector) fetchProbe(ctx context.Context, origin string) *soft404Probe {
+	probe := &soft404Probe{}
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return probe
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	page, err := detector.fetcher.Fetch(ctx, NewFetchRequest(origin+"/sentrylink-"+hex.EncodeToString(random)))
+	if err != nil || page.StatusCode != http.StatusOK {
+		return probe
+	}
+	probe.found = true
+	probe.finalUrl = page.FinalUrl
+	probe.words = words(pageText(page.Body))
+	return probe
+}
+
+// pageText returns the visible text of an HTML document.
+func pageText(document string) string {
+	var text []string
+	skip := 0
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(text, " ")
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				text = append(text, strings.Fields(string(tokenizer.Text()))...)
+			}
+		}
+	}
+}
+
+func words(text string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if len(set) >= soft404MaxWords {
+			break
+		}
+		set[word] = true
+	}
+	return set
+}
+
+// similarity returns the Jaccard index of two sets of words.
+func similarity(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	common := 0
+	for word := range a {
+		if b[word] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
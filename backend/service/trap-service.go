@@ -0,0 +1,179 @@
+package service
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+const (
+	defaultTrapSegmentRepeats    = 3
+	defaultTrapQueryCombinations = 32
+	defaultTrapPatternUrls       = 1000
+)
+
+var (
+	trapNumber = regexp.MustCompile(`[0-9]+`)
+	trapId     = regexp.MustCompile(`^[0-9a-fA-F-]{16,}$`)
+)
+
+// TrapPolicy holds the thresholds past which a crawler stops following the
+// URLs of a pattern, 0 disabling a heuristic.
+type TrapPolicy struct {
+	SegmentRepeats    int // Occurrences of a segment in the path of a URL
+	QueryCombinations int // Sets of query parameter names used on a path
+	PatternUrls       int // URLs of a pattern, their ids left out
+}
+
+// trapDetector tells apart the URLs a crawl can follow from the ones of a
+// crawler trap. It is only used by the dispatcher of the crawl.
+type trapDetector struct {
+	policy       TrapPolicy
+	combinations map[string]map[string]bool
+	patterns     map[string]int
+	traps        map[string]*schemas.CrawlTrap
+	order        []string
+}
+
+func newTrapDetector(policy TrapPolicy) *trapDetector {
+	return &trapDetector{
+		policy:       policy,
+		combinations: make(map[string]map[string]bool),
+		patterns:     make(map[string]int),
+		traps:        make(map[string]*schemas.CrawlTrap),
+	}
+}
+
+// Allow tells whether a URL may be followed, recording the trap it falls
+// in otherwise.
+func (detector *trapDetector) Allow(rawURL string) bool {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	segments := strings.FieldsFunc(target.EscapedPath(), func(r rune) bool { return r == '/' })
+	path := strings.ToLower(target.Host) + trapPath(segments)
+
+	if limit := detector.policy.SegmentRepeats; limit > 0 {
+		occurrences := make(map[string]int, len(segments))
+		for _, segment := range segments {
+			occurrences[segment]++
+			if occurrences[segment] > limit {
+				detector.record(schemas.TrapRepeatingPath, path, rawURL)
+				return false
+			}
+		}
+	}
+
+	names := queryNames(target.Query())
+	if limit := detector.policy.QueryCombinations; limit > 0 && names != "" {
+		combinations := detector.combinations[path]
+		if combinations == nil {
+			combinations = make(map[string]bool)
+			detector.combinations[path] = combinations
+		}
+		if !combinations[names] {
+			if len(combinations) >= limit {
+				detector.record(schemas.TrapQueryCombinations, path, rawURL)
+				return false
+			}
+			combinations[names] = true
+		}
+	}
+
+	if limit := detector.policy.PatternUrls; limit > 0 {
+		pattern := path
+		if names != "" {
+			pattern += "?" + names
+		}
+		if detector.patterns[pattern] >= limit {
+			detector.record(schemas.TrapUrlPattern, pattern, rawURL)
+			return false
+		}
+		detector.patterns[pattern]++
+	}
+	return true
+}
+
+func (detector *trapDetector) record(kind string, pattern string, rawURL string) {
+	key := kind + " " + pattern
+	trap, found := detector.traps[key]
+	if !found {
+		trap = &schemas.CrawlTrap{Kind: kind, Pattern: pattern, Example: rawURL}
+		detector.traps[key] = trap
+		detector.order = append(detector.order, key)
+	}
+	trap.Skipped++
+}
+
+// Traps returns the traps detected, in the order they were.
+func (detector *trapDetector) Traps() []schemas.CrawlTrap {
+	traps := make([]schemas.CrawlTrap, 0, len(detector.order))
+	for _, key := range detector.order {
+		traps = append(traps, *detector.traps[key])
+	}
+	return traps
+}
+
+// Drain returns the traps whose URLs were not followed since the last call,
+// with the number of them.
+func (detector *trapDetector) Drain() []schemas.CrawlTrap {
+	traps := []schemas.CrawlTrap{}
+	for _, key := range detector.order {
+		trap := detector.traps[key]
+		if trap.Skipped > 0 {
+			traps = append(traps, *trap)
+			trap.Skipped = 0
+		}
+	}
+	return traps
+}
+
+// trapPath returns a path with its ids replaced by placeholders, so that
+// the pages of a calendar or of a catalog share it.
+func trapPath(segments []string) string {
+	var path strings.Builder
+	for _, segment := range segments {
+		path.WriteByte('/')
+		if trapId.MatchString(segment) && trapNumber.MatchString(segment) {
+			path.WriteString("{id}")
+		} else {
+			path.WriteString(trapNumber.ReplaceAllLiteralString(segment, "{n}"))
+		}
+	}
+	if path.Len() == 0 {
+		return "/"
+	}
+	return path.String()
+}
+
+// queryNames returns the sorted names of the parameters of a query, their
+// numbers replaced by placeholders.
+func queryNames(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, trapNumber.ReplaceAllLiteralString(name, "{n}"))
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	return strings.Join(names, "&")
+}
+
+// mergeTraps adds the traps detected by a run of a crawl to the ones of its
+// previous runs.
+func mergeTraps(traps []schemas.CrawlTrap, detected []schemas.CrawlTrap) []schemas.CrawlTrap {
+	for _, trap := range detected {
+		i := slices.IndexFunc(traps, func(known schemas.CrawlTrap) bool {
+			return known.Kind == trap.Kind && known.Pattern == trap.Pattern
+		})
+		if i < 0 {
+			traps = append(traps, trap)
+		} else {
+			traps[i].Skipped += trap.Skipped
+		}
+	}
+	return traps
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+func TestTrapDetectorAllow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TrapPolicy
+		urls   []string
+		want   []bool
+		traps  []schemas.CrawlTrap
+	}{
+		{
+			name:   "disabled",
+			policy: TrapPolicy{},
+			urls:   []string{"https://example.com/a/a/a/a/a", "https://example.com/p/1", "https://example.com/p/2"},
+			want:   []bool{true, true, true},
+		},
+		{
+			name:   "repeating segment",
+			policy: TrapPolicy{SegmentRepeats: 2},
+			urls:   []string{"https://example.com/a/b/a", "https://example.com/a/b/a/b/a"},
+			want:   []bool{true, false},
+			traps:  []schemas.CrawlTrap{{Kind: schemas.TrapRepeatingPath, Pattern: "example.com/a/b/a/b/a", Example: "https://example.com/a/b/a/b/a", Skipped: 1}},
+		},
+		{
+			name:   "query combinations",
+			policy: TrapPolicy{QueryCombinations: 2},
+			urls: []string{
+				"https://example.com/list?a=1",
+				"https://example.com/list?b=1",
+				"https://example.com/list?a=2",
+				"https://example.com/list?a=1&b=1",
+				"https://example.com/other?a=1&b=1",
+			},
+			want:  []bool{true, true, true, false, true},
+			traps: []schemas.CrawlTrap{{Kind: schemas.TrapQueryCombinations, Pattern: "example.com/list", Example: "https://example.com/list?a=1&b=1", Skipped: 1}},
+		},
+		{
+			name:   "numbered parameters",
+			policy: TrapPolicy{QueryCombinations: 1},
+			urls:   []string{"https://example.com/list?f1=a", "https://example.com/list?f2=a"},
+			want:   []bool{true, true},
+		},
+		{
+			name:   "urls of a pattern",
+			policy: TrapPolicy{PatternUrls: 2},
+			urls: []string{
+				"https://example.com/day/2024-01-01",
+				"https://example.com/day/2024-01-02",
+				"https://example.com/day/2024-01-03",
+				"https://example.com/day/2024-01-04",
+				"https://example.com/month/2024-01",
+			},
+			want: []bool{true, true, false, false, true},
+			traps: []schemas.CrawlTrap{
+				{Kind: schemas.TrapUrlPattern, Pattern: "example.com/day/{n}-{n}-{n}", Example: "https://example.com/day/2024-01-03", Skipped: 2},
+			},
+		},
+		{
+			name:   "ids",
+			policy: TrapPolicy{PatternUrls: 1},
+			urls:   []string{"https://example.com/user/0f8fad5b-d9cb-469f-a165-70867728950e", "https://example.com/user/7c9e6679-7425-40de-944b-e07fc1f90ae7"},
+			want:   []bool{true, false},
+			traps:  []schemas.CrawlTrap{{Kind: schemas.TrapUrlPattern, Pattern: "example.com/user/{id}", Example: "https://example.com/user/7c9e6679-7425-40de-944b-e07fc1f90ae7", Skipped: 1}},
+		},
+		{
+			name:   "pattern with a query",
+			policy: TrapPolicy{PatternUrls: 1},
+			urls:   []string{"https://example.com/p/1?sort=asc", "https://example.com/p/2", "https://example.com/p/3?sort=desc"},
+			want:   []bool{true, true, false},
+			traps:  []schemas.CrawlTrap{{Kind: schemas.TrapUrlPattern, Pattern: "example.com/p/{n}?sort", Example: "https://example.com/p/3?sort=desc", Skipped: 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detector := newTrapDetector(test.policy)
+			for i, url := range test.urls {
+				if got := detector.Allow(url); got != test.want[i] {
+					t.Errorf("Allow(%q) = %v, want %v", url, got, test.want[i])
+				}
+			}
+			traps := detector.Traps()
+			if !slices.Equal(traps, test.traps) {
+				t.Errorf("traps = %+v, want %+v", traps, test.traps)
+			}
+		})
+	}
+}
+
+func TestTrapDetectorDrain(t *testing.T) {
+	detector := newTrapDetector(TrapPolicy{PatternUrls: 1})
+	for _, url := range []string{"https://example.com/p/1", "https://example.com/p/2", "https://example.com/p/3"} {
+		detector.Allow(url)
+	}
+	if traps := detector.Drain(); len(traps) != 1 || traps[0].Skipped != 2 {
+		t.Fatalf("first Drain() = %+v, want one trap with 2 skipped URLs", traps)
+	}
+	if traps := detector.Drain(); len(traps) != 0 {
+		t.Errorf("second Drain() = %+v, want no trap", traps)
+	}
+	detector.Allow("https://example.com/p/4")
+	if traps := detector.Drain(); len(traps) != 1 || traps[0].Skipped != 1 {
+		t.Errorf("third Drain() = %+v, want one trap with 1 skipped URL", traps)
+	}
+}
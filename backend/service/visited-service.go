@@ -0,0 +1,220 @@
+package service
+
+import (
+	"hash/fnv"
+	"math"
+
+	"github.com/Tom-Mendy/SentryLink/repository"
+	"github.com/Tom-Mendy/SentryLink/schemas"
+)
+
+const (
+	// visitedMemoryLimit is the page budget past which a crawl keeps its
+	// visited URLs in the database rather than in memory.
+	visitedMemoryLimit = 100000
+	// bloomFalsePositives is the share of new URLs looked up in the store.
+	bloomFalsePositives = 0.01
+	// Recently visited URLs kept in memory, the links of the navigation of a
+	// site being found on every page
+	visitedRecentUrls = 1 << 15
+	visitedBatchSize  = 1000
+)
+
+// VisitedStore is the exact set of the URLs visited by a crawl, kept out of
+// memory.
+type VisitedStore interface {
+	SaveAll(urls []string)
+	Contains(url string) bool
+	Each(fn func(url string))
+	Clear()
+}
+
+// visitedSet is the set of the URLs queued by a crawl, used by its
+// dispatcher only.
+type visitedSet interface {
+	Contains(url string) bool
+	// Add adds a URL which is not in the set yet.
+	Add(url string)
+	Len() int
+	Each(fn func(url string))
+}
+
+type memoryVisitedSet map[string]bool
+
+func (set memoryVisitedSet) Contains(url string) bool {
+	return set[url]
+}
+
+func (set memoryVisitedSet) Add(url string) {
+	set[url] = true
+}
+
+func (set memoryVisitedSet) Len() int {
+	return len(set)
+}
+
+func (set memoryVisitedSet) Each(fn func(url string)) {
+	for url := range set {
+		fn(url)
+	}
+}
+
+// bloomVisitedSet only keeps a Bloom filter of the visited URLs in memory,
+// the store only being queried when the filter may contain a URL.
+type bloomVisitedSet struct {
+	filter  *bloomFilter
+	store   VisitedStore
+	unsaved []string
+	// Two generations of the URLs found visited recently
+	recent   map[string]bool
+	previous map[string]bool
+	len      int
+}
+
+func newBloomVisitedSet(store VisitedStore, expected int) *bloomVisitedSet {
+	return &bloomVisitedSet{
+		filter: newBloomFilter(expected, bloomFalsePositives),
+		store:  store,
+		recent: make(map[string]bool),
+	}
+}
+
+func (set *bloomVisitedSet) Add(url string) {
+	set.filter.Add(url)
+	set.remember(url)
+	set.unsaved = append(set.unsaved, url)
+	if len(set.unsaved) >= visitedBatchSize {
+		set.flush()
+	}
+	set.len++
+}
+
+func (set *bloomVisitedSet) Contains(url string) bool {
+	if !set.filter.MayContain(url) {
+		return false
+	}
+	if set.recent[url] || set.previous[url] {
+		set.remember(url)
+		return true
+	}
+	set.flush()
+	if !set.store.Contains(url) {
+		return false
+	}
+	set.remember(url)
+	return true
+}
+
+func (set *bloomVisitedSet) remember(url string) {
+	if len(set.recent) >= visitedRecentUrls {
+		set.previous = set.recent
+		set.recent = make(map[string]bool)
+	}
+	set.recent[url] = true
+}
+
+func (set *bloomVisitedSet) flush() {
+	if len(set.unsaved) == 0 {
+		return
+	}
+	set.store.SaveAll(set.unsaved)
+	set.unsaved = set.unsaved[:0]
+}
+
+func (set *bloomVisitedSet) Len() int {
+	return set.len
+}
+
+func (set *bloomVisitedSet) Each(fn func(url string)) {
+	set.flush()
+	set.store.Each(fn)
+}
+
+// bloomFilter is a set which may report URLs it does not contain, never
+// missing one it does.
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// newBloomFilter sizes a filter for expected URLs, reporting a share of
+// falsePositives of the ones it does not contain.
+func newBloomFilter(expected int, falsePositives float64) *bloomFilter {
+	n := float64(max(expected, 1024))
+	m := math.Ceil(-n * math.Log(falsePositives) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+	return &bloomFilter{
+		bits:   make([]uint64, (uint64(m)+63)/64),
+		hashes: uint64(k),
+	}
+}
+
+// positions yields the bits of a URL, derived from two halves of its hash.
+func (filter *bloomFilter) positions(url string, fn func(bit uint64)) {
+	hash := urlHash(url)
+	h1, h2 := hash&math.MaxUint32, hash>>32|1
+	size := uint64(len(filter.bits)) * 64
+	for i := uint64(0); i < filter.hashes; i++ {
+		fn((h1 + i*h2) % size)
+	}
+}
+
+func (filter *bloomFilter) Add(url string) {
+	filter.positions(url, func(bit uint64) {
+		filter.bits[bit/64] |= 1 << (bit % 64)
+	})
+}
+
+func (filter *bloomFilter) MayContain(url string) bool {
+	contained := true
+	filter.positions(url, func(bit uint64) {
+		if filter.bits[bit/64]&(1<<(bit%64)) == 0 {
+			contained = false
+		}
+	})
+	return contained
+}
+
+func urlHash(url string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(url))
+	return hash.Sum64()
+}
+
+// crawlVisitedStore keeps the URLs visited by a crawl job in the database.
+type crawlVisitedStore struct {
+	jobId      uint64
+	repository repository.CrawlVisitedRepository
+}
+
+func NewCrawlVisitedStore(jobId uint64, repository repository.CrawlVisitedRepository) VisitedStore {
+	return &crawlVisitedStore{jobId: jobId, repository: repository}
+}
+
+func (store *crawlVisitedStore) SaveAll(urls []string) {
+	records := make([]schemas.CrawlVisitedUrl, 0, len(urls))
+	for _, url := range urls {
+		records = append(records, schemas.CrawlVisitedUrl{
+			CrawlJobId: store.jobId,
+			Hash:       int64(urlHash(url)),
+			Url:        url,
+		})
+	}
+	store.repository.SaveAll(records)
+}
+
+func (store *crawlVisitedStore) Contains(url string) bool {
+	return store.repository.Exists(store.jobId, int64(urlHash(url)), url)
+}
+
+func (store *crawlVisitedStore) Each(fn func(url string)) {
+	store.repository.FindByCrawlJob(store.jobId, func(urls []schemas.CrawlVisitedUrl) {
+		for _, url := range urls {
+			fn(url.Url)
+		}
+	})
+}
+
+func (store *crawlVisitedStore) Clear() {
+	store.repository.DeleteByCrawlJob(store.jobId)
+}
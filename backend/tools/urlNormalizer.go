@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultTrackingParams are the query parameters stripped by default, a
+// trailing * matching any suffix.
+var DefaultTrackingParams = []string{
+	"utm_*", "gclid", "dclid", "fbclid", "msclkid", "yclid", "mc_cid", "mc_eid", "_ga", "_hsenc", "_hsmi",
+}
+
+// UrlNormalizer rewrites URLs so that the same resource is always named the
+// same way.
+type UrlNormalizer struct {
+	// Query parameters removed from the URLs
+	TrackingParams []string
+}
+
+// NormalizeUrl normalizes a URL without stripping any query parameter, the
+// raw URL being returned when it cannot be parsed.
+func NormalizeUrl(rawURL string) string {
+	normalized, err := UrlNormalizer{}.Normalize(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return normalized
+}
+
+// Normalize folds the case of the scheme and the host, removes the default
+// port, the fragment, the dot segments and the tracking parameters, and
+// sorts the query parameters.
+func (normalizer UrlNormalizer) Normalize(rawURL string) (string, error) {
+	target, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	target.Scheme = strings.ToLower(target.Scheme)
+	target.Host = strings.ToLower(target.Host)
+	if port := target.Port(); (target.Scheme == "http" && port == "80") || (target.Scheme == "https" && port == "443") {
+		target.Host = strings.TrimSuffix(target.Host, ":"+port)
+	}
+	target.Fragment, target.RawFragment = "", ""
+
+	escaped := removeDotSegments(target.EscapedPath())
+	if escaped == "" && target.Host != "" {
+		escaped = "/"
+	}
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", err
+	}
+	target.Path, target.RawPath = path, escaped
+
+	target.RawQuery = normalizer.normalizeQuery(target.RawQuery)
+	target.ForceQuery = false
+	return target.String(), nil
+}
+
+// normalizeQuery strips the tracking parameters and sorts the others by name,
+// keeping their encoding and the order of the values of a parameter.
+func (normalizer UrlNormalizer) normalizeQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	type param struct {
+		name string
+		raw  string
+	}
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if normalizer.tracking(name) {
+			continue
+		}
+		params = append(params, param{name: name, raw: raw})
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+	raws := make([]string, 0, len(params))
+	for _, p := range params {
+		raws = append(raws, p.raw)
+	}
+	return strings.Join(raws, "&")
+}
+
+func (normalizer UrlNormalizer) tracking(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range normalizer.TrackingParams {
+		pattern = strings.ToLower(pattern)
+		if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDotSegments removes the "." and ".." segments of a path as described
+// by RFC 3986, section 5.2.4.
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	output := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				output = append(output, "")
+			}
+		case "..":
+			// The leading empty segment of an absolute path stays.
+			if len(output) > 1 || (len(output) == 1 && output[0] != "") {
+				output = output[:len(output)-1]
+			}
+			if last {
+				output = append(output, "")
+			}
+		default:
+			output = append(output, segment)
+		}
+	}
+	return strings.Join(output, "/")
+}
@@ -0,0 +1,49 @@
+package tools
+
+import "testing"
+
+func TestUrlNormalizerNormalize(t *testing.T) {
+	tests := []struct {
+		name       string
+		normalizer UrlNormalizer
+		url        string
+		want       string
+	}{
+		{"case of the scheme and host", UrlNormalizer{}, "HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"default http port", UrlNormalizer{}, "http://example.com:80/a", "http://example.com/a"},
+		{"default https port", UrlNormalizer{}, "https://example.com:443/a", "https://example.com/a"},
+		{"other port kept", UrlNormalizer{}, "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"fragment", UrlNormalizer{}, "https://example.com/a#top", "https://example.com/a"},
+		{"empty path", UrlNormalizer{}, "https://example.com", "https://example.com/"},
+		{"dot segments", UrlNormalizer{}, "https://example.com/a/./b/../c", "https://example.com/a/c"},
+		{"dot segments above the root", UrlNormalizer{}, "https://example.com/../../a", "https://example.com/a"},
+		{"trailing dot dot", UrlNormalizer{}, "https://example.com/a/b/..", "https://example.com/a/"},
+		{"escaping kept", UrlNormalizer{}, "https://example.com/a%2Fb", "https://example.com/a%2Fb"},
+		{"query sorted", UrlNormalizer{}, "https://example.com/?b=2&a=1", "https://example.com/?a=1&b=2"},
+		{"values of a parameter in order", UrlNormalizer{}, "https://example.com/?b=2&a=3&a=1", "https://example.com/?a=3&a=1&b=2"},
+		{"empty query", UrlNormalizer{}, "https://example.com/a?", "https://example.com/a"},
+		{"spaces trimmed", UrlNormalizer{}, "  https://example.com/a ", "https://example.com/a"},
+		{"tracking kept by default", UrlNormalizer{}, "https://example.com/?utm_source=x&id=1", "https://example.com/?id=1&utm_source=x"},
+		{"tracking wildcard", UrlNormalizer{TrackingParams: DefaultTrackingParams}, "https://example.com/?utm_source=x&UTM_Medium=y&id=1", "https://example.com/?id=1"},
+		{"tracking exact name", UrlNormalizer{TrackingParams: []string{"gclid"}}, "https://example.com/?gclid=1&gclidx=2", "https://example.com/?gclidx=2"},
+		{"escaped tracking name", UrlNormalizer{TrackingParams: []string{"utm_*"}}, "https://example.com/?utm%5Fsource=x", "https://example.com/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.normalizer.Normalize(test.url)
+			if err != nil {
+				t.Fatalf("Normalize(%q) failed: %v", test.url, err)
+			}
+			if got != test.want {
+				t.Errorf("Normalize(%q) = %q, want %q", test.url, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeUrlInvalid(t *testing.T) {
+	const invalid = "http://[::1"
+	if got := NormalizeUrl(invalid); got != invalid {
+		t.Errorf("NormalizeUrl(%q) = %q, want it unchanged", invalid, got)
+	}
+}